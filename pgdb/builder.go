@@ -0,0 +1,329 @@
+package pgdb
+
+/*
+	builder for queries assembled at run time, e.g. from API filters
+	each condition is written with its own placeholders starting at $1, which are renumbered as the query is assembled.
+	Sorting is only allowed on whitelisted fields, and pages after the first are fetched with keyset pagination:
+	the cursor holds the sort values of the last row returned, and the next page starts after that row
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/cambefus/gcp_go_utils/util"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor - the cursor is malformed, has been tampered with, or does not match the sort order of the query
+var ErrInvalidCursor = util.ErrCursorInvalid
+
+// Builder - assembles a SELECT statement, errors are reported by Build
+type Builder struct {
+	base   string
+	conds  []string
+	args   []interface{}
+	order  []SortKey
+	after  []interface{}
+	cursor string
+	signer *util.CursorSigner
+	limit  int
+	err    error
+}
+
+// SortKey - a column or expression a query is sorted by
+type SortKey struct {
+	Expr string
+	Desc bool
+}
+
+// NewBuilder - base is the statement up to, but not including, the WHERE clause, e.g. "SELECT id, name FROM users"
+// it may use placeholders for args
+func NewBuilder(base string, args ...interface{}) *Builder {
+	this := new(Builder)
+	this.base, this.args, this.err = renumber(base, 0, args)
+	return this
+}
+
+// Where - adds a condition, combined with the others using AND. Placeholders in cond start at $1
+func (b *Builder) Where(cond string, args ...interface{}) *Builder {
+	c, a, err := renumber(cond, len(b.args), args)
+	if err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.conds = append(b.conds, `(`+c+`)`)
+	b.args = append(b.args, a...)
+	return b
+}
+
+// In - adds "column = ANY($n)", values is a slice such as the result of util.UniqueInts. An empty slice matches no rows
+// column is included in the statement as given, so must not come from user input
+func (b *Builder) In(column string, values interface{}) *Builder {
+	return b.Where(column+` = ANY($1)`, values)
+}
+
+// OrderBy - sort by spec, a comma separated list of fields each optionally prefixed with "-" for descending
+// e.g. "-created,id". allowed maps the fields that may be used to the column or expression to sort by
+// For keyset pagination the final field must be unique, e.g. a primary key
+func (b *Builder) OrderBy(spec string, allowed map[string]string) *Builder {
+	for _, f := range strings.Split(spec, `,`) {
+		f = strings.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		desc := strings.HasPrefix(f, `-`)
+		f = strings.TrimPrefix(strings.TrimPrefix(f, `-`), `+`)
+		expr, ok := allowed[f]
+		if !ok {
+			b.setErr(fmt.Errorf(`sorting by %q is not supported`, f))
+			return b
+		}
+		b.order = append(b.order, SortKey{Expr: expr, Desc: desc})
+	}
+	return b
+}
+
+// Limit - maximum number of rows, zero for no limit
+func (b *Builder) Limit(n int) *Builder {
+	b.limit = n
+	return b
+}
+
+// Page - Limit(size), starting after the row described by cursor, an empty cursor for the first page
+func (b *Builder) Page(size int, cursor string) *Builder {
+	b.limit = size
+	b.cursor = cursor
+	return b
+}
+
+// SignedPage - Page, with a cursor created by SignCursor. Build fails with a *util.CursorError
+// if the cursor has been altered, has expired or was created for a different sort order
+func (b *Builder) SignedPage(size int, cursor string, signer *util.CursorSigner) *Builder {
+	b.Page(size, cursor)
+	b.signer = signer
+	return b
+}
+
+// After - starts after the row whose sort values are given, in the order of the OrderBy fields
+func (b *Builder) After(values ...interface{}) *Builder {
+	b.after = values
+	return b
+}
+
+// Build - the statement and its arguments
+func (b *Builder) Build() (string, []interface{}, error) {
+	if b.err != nil {
+		return ``, nil, b.err
+	}
+	conds := b.conds
+	args := b.args
+	after := b.after
+	if len(b.cursor) > 0 {
+		var err error
+		if b.signer != nil {
+			after, err = decodeSigned(b.order, b.signer, b.cursor)
+		} else {
+			after, err = DecodeCursor(b.cursor)
+		}
+		if err != nil {
+			return ``, nil, err
+		}
+	}
+	if len(after) > 0 {
+		c, a, err := KeysetCondition(b.order, after, len(args))
+		if err != nil {
+			return ``, nil, err
+		}
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	var sb strings.Builder
+	sb.WriteString(b.base)
+	if len(conds) > 0 {
+		sb.WriteString(` WHERE `)
+		sb.WriteString(strings.Join(conds, ` AND `))
+	}
+	if len(b.order) > 0 {
+		sb.WriteString(` ORDER BY `)
+		sb.WriteString(orderBy(b.order))
+	}
+	if b.limit > 0 {
+		sb.WriteString(` LIMIT ` + strconv.Itoa(b.limit))
+	}
+	return sb.String(), args, nil
+}
+
+// Cursor - a cursor for the page following the row whose sort values are given, signed when signer is not nil
+func (b *Builder) Cursor(signer *util.CursorSigner, values ...interface{}) (string, error) {
+	if signer == nil {
+		return EncodeCursor(values...)
+	}
+	return SignCursor(signer, b.order, values...)
+}
+
+func (b *Builder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// KeysetCondition - the condition selecting rows after values in the given order, placeholders start at offset+1
+// when every term sorts in the same direction this is a row comparison, e.g. (a, b) > ($1, $2), which can use an index
+// on (a, b). Mixed directions are expanded to a > $1 OR (a = $1 AND b < $2)
+func KeysetCondition(order []SortKey, values []interface{}, offset int) (string, []interface{}, error) {
+	if len(order) == 0 || len(values) != len(order) {
+		return ``, nil, ErrInvalidCursor
+	}
+	values = append([]interface{}(nil), values...)
+	for i, v := range values {
+		if n, ok := v.(json.Number); ok {
+			// sent as text, so the server parses it as the column's type
+			values[i] = n.String()
+		}
+	}
+	p := func(i int) string {
+		return `$` + strconv.Itoa(offset+i+1)
+	}
+	uniform := true
+	for _, o := range order {
+		uniform = uniform && o.Desc == order[0].Desc
+	}
+	op := func(desc bool) string {
+		if desc {
+			return ` < `
+		}
+		return ` > `
+	}
+	if uniform {
+		cols := make([]string, len(order))
+		params := make([]string, len(order))
+		for i, o := range order {
+			cols[i] = o.Expr
+			params[i] = p(i)
+		}
+		return `((` + strings.Join(cols, `, `) + `)` + op(order[0].Desc) + `(` + strings.Join(params, `, `) + `))`, values, nil
+	}
+	ors := make([]string, len(order))
+	for i, o := range order {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, order[j].Expr+` = `+p(j))
+		}
+		ands = append(ands, o.Expr+op(o.Desc)+p(i))
+		ors[i] = `(` + strings.Join(ands, ` AND `) + `)`
+	}
+	return `(` + strings.Join(ors, ` OR `) + `)`, values, nil
+}
+
+// SignedKeysetCondition - KeysetCondition for a cursor created by SignCursor with the same order
+func SignedKeysetCondition(order []SortKey, signer *util.CursorSigner, cursor string, offset int) (string, []interface{}, error) {
+	values, err := decodeSigned(order, signer, cursor)
+	if err != nil {
+		return ``, nil, err
+	}
+	return KeysetCondition(order, values, offset)
+}
+
+// SignCursor - a signed cursor holding the sort values of the last row of a page, along with the order they belong to
+// so the cursor cannot be replayed against a query sorted differently
+func SignCursor(signer *util.CursorSigner, order []SortKey, values ...interface{}) (string, error) {
+	return signer.Encode(append([]interface{}{orderBy(order)}, values...)...)
+}
+
+// decodeSigned - the values of a SignCursor cursor, checking it was created for order and holds at least one value
+func decodeSigned(order []SortKey, signer *util.CursorSigner, cursor string) ([]interface{}, error) {
+	values, err := signer.Decode(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < 2 || values[0] != orderBy(order) {
+		return nil, &util.CursorError{Err: ErrInvalidCursor}
+	}
+	return values[1:], nil
+}
+
+// orderBy - the ORDER BY list for order, e.g. "u.created_at DESC, u.id"
+func orderBy(order []SortKey) string {
+	terms := make([]string, len(order))
+	for i, o := range order {
+		terms[i] = o.Expr
+		if o.Desc {
+			terms[i] += ` DESC`
+		}
+	}
+	return strings.Join(terms, `, `)
+}
+
+// EncodeCursor - an unsigned, url safe token holding the sort values of the last row of a page
+func EncodeCursor(values ...interface{}) (string, error) {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return ``, err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor - the values given to EncodeCursor, numbers are returned as json.Number and times as strings
+// EncodeCursor tokens can be altered by clients, use util.CursorSigner where that matters
+func DecodeCursor(cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var result []interface{}
+	if err = d.Decode(&result); err != nil || len(result) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return result, nil
+}
+
+// renumber - shifts the placeholders in q by offset, checking they are $1 to $n for n args with none skipped
+// placeholders inside quoted strings and identifiers are left alone
+func renumber(q string, offset int, args []interface{}) (string, []interface{}, error) {
+	var sb strings.Builder
+	var quote byte
+	highest := 0
+	used := make(map[int]bool)
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9':
+			j := i + 1
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(q[i+1 : j])
+			if n == 0 {
+				return ``, nil, fmt.Errorf(`%q uses placeholder $0`, q)
+			}
+			used[n] = true
+			if n > highest {
+				highest = n
+			}
+			sb.WriteString(`$` + strconv.Itoa(n+offset))
+			i = j - 1
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if highest != len(args) {
+		return ``, nil, fmt.Errorf(`%q uses %d placeholders but has %d arguments`, q, highest, len(args))
+	}
+	for n := 1; n <= highest; n++ {
+		if !used[n] {
+			return ``, nil, fmt.Errorf(`%q skips placeholder $%d`, q, n)
+		}
+	}
+	return sb.String(), args, nil
+}
@@ -0,0 +1,105 @@
+package pgdb
+
+import (
+	"errors"
+	"github.com/cambefus/gcp_go_utils/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var sortable = map[string]string{`id`: `u.id`, `name`: `u.name`, `created`: `u.created_at`}
+
+func Test_Builder(t *testing.T) {
+	ids := util.UniqueInts([]int{3, 1, 3})
+	q, args, e := NewBuilder(`SELECT id FROM users u JOIN orgs o ON o.id = u.org_id AND o.region = $1`, `eu`).
+		Where(`u.name ILIKE $1 OR u.email ILIKE $1`, `%bob%`).
+		In(`u.id`, ids).
+		Where(`u.note <> '$1' AND u.created_at > $1`, time.Time{}).
+		OrderBy(`-created, id`, sortable).
+		Limit(10).
+		Build()
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := `SELECT id FROM users u JOIN orgs o ON o.id = u.org_id AND o.region = $1 ` +
+		`WHERE (u.name ILIKE $2 OR u.email ILIKE $2) AND (u.id = ANY($3)) AND (u.note <> '$1' AND u.created_at > $4) ` +
+		`ORDER BY u.created_at DESC, u.id LIMIT 10`
+	if q != want {
+		t.Errorf("Build() = %s\nwant %s", q, want)
+	}
+	if len(args) != 4 || !reflect.DeepEqual(args[2], []int{3, 1}) {
+		t.Errorf(`unexpected args %v`, args)
+	}
+
+	if _, _, e = NewBuilder(`SELECT 1`).OrderBy(`password`, sortable).Build(); e == nil {
+		t.Error(`Expected error sorting by a field that is not allowed`)
+	}
+	if _, _, e = NewBuilder(`SELECT 1`).Where(`a = $1 AND b = $2`, 1).Build(); e == nil {
+		t.Error(`Expected error for missing argument`)
+	}
+	if _, _, e = NewBuilder(`SELECT 1`).Where(`a = $2`, 1, 2).Build(); e == nil {
+		t.Error(`Expected error for skipped placeholder`)
+	}
+	if _, _, e = NewBuilder(`SELECT 1`).Where(`a = $0`).Build(); e == nil {
+		t.Error(`Expected error for placeholder $0`)
+	}
+}
+
+func Test_KeysetPagination(t *testing.T) {
+	c, e := EncodeCursor(`2024-01-02T00:00:00Z`, 42)
+	if e != nil {
+		t.Fatal(e)
+	}
+	q, args, e := NewBuilder(`SELECT id FROM users u`).Where(`u.active`).OrderBy(`created,id`, sortable).Page(20, c).Build()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if q != `SELECT id FROM users u WHERE (u.active) AND ((u.created_at, u.id) > ($1, $2)) ORDER BY u.created_at, u.id LIMIT 20` {
+		t.Errorf(`unexpected statement %s`, q)
+	}
+	if !reflect.DeepEqual(args, []interface{}{`2024-01-02T00:00:00Z`, `42`}) {
+		t.Errorf(`unexpected args %#v`, args)
+	}
+
+	q, _, _ = NewBuilder(`SELECT id FROM users u`).OrderBy(`-name,id`, sortable).After(`bob`, 7).Build()
+	if q != `SELECT id FROM users u WHERE ((u.name < $1) OR (u.name = $1 AND u.id > $2)) ORDER BY u.name DESC, u.id` {
+		t.Errorf(`unexpected mixed direction statement %s`, q)
+	}
+
+	if _, _, e = NewBuilder(`SELECT 1`).OrderBy(`id`, sortable).Page(20, c).Build(); !errors.Is(e, ErrInvalidCursor) {
+		t.Errorf(`Expected ErrInvalidCursor for a cursor not matching the sort, got %v`, e)
+	}
+	if _, e = DecodeCursor(`not a cursor!`); !errors.Is(e, ErrInvalidCursor) {
+		t.Errorf(`Expected ErrInvalidCursor, got %v`, e)
+	}
+}
+
+func Test_SignedKeyset(t *testing.T) {
+	s, _ := util.NewCursorSigner([]byte(`0123456789abcdef0123456789abcdef`), time.Hour)
+	order := []SortKey{{Expr: `name`}, {Expr: `id`}}
+	c, _ := SignCursor(s, order, `bob`, 7)
+	cond, args, e := SignedKeysetCondition(order, s, c, 2)
+	if e != nil || cond != `((name, id) > ($3, $4))` || !reflect.DeepEqual(args, []interface{}{`bob`, `7`}) {
+		t.Errorf(`SignedKeysetCondition() = %s %#v %v`, cond, args, e)
+	}
+	if _, _, e = SignedKeysetCondition([]SortKey{{Expr: `name`, Desc: true}, {Expr: `id`}}, s, c, 0); !errors.Is(e, ErrInvalidCursor) {
+		t.Errorf(`Expected rejected cursor for a different order, got %v`, e)
+	}
+
+	b := NewBuilder(`SELECT id FROM users u`).OrderBy(`name,id`, sortable)
+	unsigned, _ := EncodeCursor(`bob`, 7)
+	plain, _ := s.Encode(`bob`, 7)
+	other, _ := SignCursor(s, order, `bob`, 7)
+	empty, _ := SignCursor(s, b.order)
+	for name, cursor := range map[string]string{`unsigned`: unsigned, `no order`: plain, `other order`: other, `no values`: empty} {
+		var ce *util.CursorError
+		if _, _, e = NewBuilder(`SELECT id FROM users u`).OrderBy(`name,id`, sortable).SignedPage(10, cursor, s).Build(); !errors.Is(e, ErrInvalidCursor) || !errors.As(e, &ce) {
+			t.Errorf(`%s: expected rejected cursor, got %v`, name, e)
+		}
+	}
+	c, _ = b.Cursor(s, `bob`, 7)
+	if _, _, e = NewBuilder(`SELECT id FROM users u`).OrderBy(`name,id`, sortable).SignedPage(10, c, s).Build(); e != nil {
+		t.Error(e)
+	}
+}
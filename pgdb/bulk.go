@@ -0,0 +1,221 @@
+package pgdb
+
+/*
+	bulk operations: COPY, batched statements in a single round trip, and upsert built from a struct
+	struct fields are matched to columns in the same way as the Select helpers, using the `db` tag
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CopyFrom - bulk loads rows into table using the COPY protocol, returns the number of rows copied
+// table may be schema qualified. src may be
+//   - a pgx.CopyFromSource, e.g. from pgx.CopyFromRows or a custom iterator
+//   - a [][]interface{} of values in column order
+//   - a slice of structs, or pointers to structs, with a field for every column
+func (p *DBPool) CopyFrom(ctx context.Context, table string, columns []string, src interface{}) (int64, error) {
+	var source pgx.CopyFromSource
+	switch s := src.(type) {
+	case pgx.CopyFromSource:
+		source = s
+	case [][]interface{}:
+		source = pgx.CopyFromRows(s)
+	default:
+		var err error
+		if source, err = newStructSource(src, columns); err != nil {
+			return 0, err
+		}
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	ctx, done := p.obs.start(ctx, `COPY `+table, nil)
+	n, err := p.DBCon.CopyFrom(ctx, tableName(table), columns, source)
+	done(n, err)
+	return n, err
+}
+
+// tableName - a possibly schema qualified name split into its parts
+func tableName(name string) pgx.Identifier {
+	return pgx.Identifier(strings.Split(name, `.`))
+}
+
+// identifier - quotes a possibly schema qualified name for use in a statement
+func identifier(name string) string {
+	return tableName(name).Sanitize()
+}
+
+// structSource - presents a slice of structs as a pgx.CopyFromSource
+type structSource struct {
+	rows  reflect.Value
+	paths [][]int
+	pos   int
+}
+
+func newStructSource(src interface{}, columns []string) (*structSource, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf(`unsupported copy source %T`, src)
+	}
+	et := v.Type().Elem()
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return nil, fmt.Errorf(`unsupported copy source %T`, src)
+	}
+	fields := structFields(et)
+	result := &structSource{rows: v, paths: make([][]int, len(columns))}
+	for i, c := range columns {
+		path, ok := fields[c]
+		if !ok {
+			return nil, &UnknownColumnError{Column: c, Type: et.String()}
+		}
+		result.paths[i] = path
+	}
+	return result, nil
+}
+
+func (s *structSource) Next() bool {
+	s.pos++
+	return s.pos <= s.rows.Len()
+}
+
+func (s *structSource) Values() ([]interface{}, error) {
+	row := reflect.Indirect(s.rows.Index(s.pos - 1))
+	if !row.IsValid() {
+		return nil, errors.New(`nil row in copy source`)
+	}
+	result := make([]interface{}, len(s.paths))
+	for i, path := range s.paths {
+		result[i] = fieldValue(row, path)
+	}
+	return result, nil
+}
+
+func (s *structSource) Err() error {
+	return nil
+}
+
+// fieldValue - the value of the field at path, nil if an embedded struct pointer on the way is nil
+func fieldValue(v reflect.Value, path []int) interface{} {
+	for i, x := range path {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v.Interface()
+}
+
+// Batch - statements sent to the database in a single round trip by SendBatch
+// the statements run in an implicit transaction, so an error causes the remaining statements to fail
+type Batch struct {
+	b   pgx.Batch
+	sql []string
+}
+
+// Queue - adds a statement to the batch
+func (b *Batch) Queue(q string, args ...interface{}) {
+	b.b.Queue(q, args...)
+	b.sql = append(b.sql, q)
+}
+
+// Len - number of statements queued
+func (b *Batch) Len() int {
+	return len(b.sql)
+}
+
+// BatchResult - outcome of one statement in a batch
+type BatchResult struct {
+	SQL          string
+	RowsAffected int64
+	Err          error
+}
+
+// SendBatch - runs every queued statement, results are in the order queued
+// the error returned is the first statement error, if any
+func (p *DBPool) SendBatch(ctx context.Context, b *Batch) ([]BatchResult, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	ctx, done := p.obs.start(ctx, `BATCH (`+strconv.Itoa(b.Len())+` statements)`, nil)
+	br := p.DBCon.SendBatch(ctx, &b.b)
+	results := make([]BatchResult, b.Len())
+	var first error
+	var total int64
+	for i, q := range b.sql {
+		ct, err := br.Exec()
+		results[i] = BatchResult{SQL: q, RowsAffected: ct.RowsAffected(), Err: err}
+		total += ct.RowsAffected()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	if err := br.Close(); err != nil && first == nil {
+		first = err
+	}
+	done(total, first)
+	return results, first
+}
+
+// BuildUpsert - builds INSERT ... ON CONFLICT (conflict) DO UPDATE from the fields of a struct (or pointer to one)
+// every non-conflict column is updated, if all columns are conflict columns the statement does nothing on conflict
+func BuildUpsert(table string, v interface{}, conflict ...string) (string, []interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return ``, nil, fmt.Errorf(`upsert requires a struct, not %T`, v)
+	}
+	if len(conflict) == 0 {
+		return ``, nil, errors.New(`upsert requires at least one conflict column`)
+	}
+	si := getStructInfo(rv.Type())
+	isConflict := make(map[string]bool, len(conflict))
+	for _, c := range conflict {
+		if _, ok := si.paths[c]; !ok {
+			return ``, nil, &UnknownColumnError{Column: c, Type: rv.Type().String()}
+		}
+		isConflict[c] = true
+	}
+	cols := make([]string, len(si.columns))
+	params := make([]string, len(si.columns))
+	args := make([]interface{}, len(si.columns))
+	var updates []string
+	for i, c := range si.columns {
+		cols[i] = pgx.Identifier{c}.Sanitize()
+		params[i] = `$` + strconv.Itoa(i+1)
+		args[i] = fieldValue(rv, si.paths[c])
+		if !isConflict[c] {
+			updates = append(updates, cols[i]+` = EXCLUDED.`+cols[i])
+		}
+	}
+	targets := make([]string, len(conflict))
+	for i, c := range conflict {
+		targets[i] = pgx.Identifier{c}.Sanitize()
+	}
+	action := `DO NOTHING`
+	if len(updates) > 0 {
+		action = `DO UPDATE SET ` + strings.Join(updates, `, `)
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s`,
+		identifier(table), strings.Join(cols, `, `), strings.Join(params, `, `),
+		strings.Join(targets, `, `), action)
+	return q, args, nil
+}
+
+// Upsert - inserts the struct into table, updating the existing row when the conflict columns match one
+func (p *DBPool) Upsert(ctx context.Context, table string, v interface{}, conflict ...string) (int, error) {
+	q, args, err := BuildUpsert(table, v, conflict...)
+	if err != nil {
+		return 0, err
+	}
+	return p.ExecuteContext(ctx, q, args...)
+}
@@ -0,0 +1,89 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_BuildUpsert(t *testing.T) {
+	nick := `Burglar`
+	q, args, e := BuildUpsert(`public.person`, &person{ID: 1, Name: `Bilbo`, Nickname: &nick}, `id`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := `INSERT INTO "public"."person" ("created_at", "id", "name", "nick") VALUES ($1, $2, $3, $4) ` +
+		`ON CONFLICT ("id") DO UPDATE SET "created_at" = EXCLUDED."created_at", "name" = EXCLUDED."name", "nick" = EXCLUDED."nick"`
+	if q != want {
+		t.Errorf("BuildUpsert() = %s\nwant %s", q, want)
+	}
+	if len(args) != 4 || args[1] != 1 || args[2] != `Bilbo` {
+		t.Errorf(`unexpected args %v`, args)
+	}
+
+	type key struct {
+		ID int `db:"id"`
+	}
+	q, _, _ = BuildUpsert(`k`, key{1}, `id`)
+	if q != `INSERT INTO "k" ("id") VALUES ($1) ON CONFLICT ("id") DO NOTHING` {
+		t.Errorf(`unexpected statement %s`, q)
+	}
+	var uc *UnknownColumnError
+	if _, _, e = BuildUpsert(`k`, key{1}, `missing`); !errors.As(e, &uc) {
+		t.Errorf(`Expected unknown column error, got %v`, e)
+	}
+}
+
+func Test_structSource(t *testing.T) {
+	src, e := newStructSource([]*person{{ID: 1, Name: `a`}, {ID: 2, Name: `b`}}, []string{`name`, `id`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var got [][]interface{}
+	for src.Next() {
+		v, _ := src.Values()
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[1][0] != `b` || got[1][1] != 2 {
+		t.Errorf(`unexpected copy rows %v`, got)
+	}
+	if _, e = newStructSource([]person{}, []string{`missing`}); e == nil {
+		t.Error(`Expected error for unknown column`)
+	}
+	if _, e = newStructSource(42, nil); e == nil {
+		t.Error(`Expected error for unsupported source`)
+	}
+}
+
+func Test_CopyAndBatch(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	_, _ = p.Execute(`create table if not exists bulk_test (id int primary key, name text)`)
+	defer p.Execute(`drop table bulk_test`)
+
+	type row struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+	n, e := p.CopyFrom(ctx, `bulk_test`, []string{`id`, `name`}, []row{{1, `a`}, {2, `b`}})
+	if n != 2 || e != nil {
+		t.Error(`CopyFrom failed`, n, e)
+	}
+	b := new(Batch)
+	b.Queue(`update bulk_test set name = $1 where id = $2`, `c`, 1)
+	b.Queue(`delete from bulk_test where id > $1`, 0)
+	r, e := p.SendBatch(ctx, b)
+	if e != nil || len(r) != 2 || r[0].RowsAffected != 1 || r[1].RowsAffected != 2 {
+		t.Error(`SendBatch failed`, r, e)
+	}
+	if _, e = p.Upsert(ctx, `bulk_test`, row{1, `d`}, `id`); e != nil {
+		t.Error(e)
+	}
+	if _, e = p.Upsert(ctx, `bulk_test`, row{1, `e`}, `id`); e != nil {
+		t.Error(e)
+	}
+	if s, _ := p.GetString(ctx, `select name from bulk_test where id = 1`); s != `e` {
+		t.Errorf(`Expected upserted name e, got %s`, s)
+	}
+}
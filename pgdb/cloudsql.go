@@ -0,0 +1,102 @@
+package pgdb
+
+/*
+	connections to Cloud SQL by instance connection name ("project:region:instance") through a pluggable dialer
+	optionally authenticating with IAM database authentication, where an OAuth token is used as the password
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"net"
+)
+
+// IAMLoginScope - OAuth scope required for IAM database authentication
+const IAMLoginScope = `https://www.googleapis.com/auth/sqlservice.login`
+
+// Dialer - opens an encrypted connection to a Cloud SQL instance
+// the Cloud SQL Go connector (cloud.google.com/go/cloudsqlconn) can be used through DialerFunc
+type Dialer interface {
+	Dial(ctx context.Context, instance string) (net.Conn, error)
+}
+
+// DialerFunc - adapts a function to the Dialer interface
+type DialerFunc func(ctx context.Context, instance string) (net.Conn, error)
+
+func (f DialerFunc) Dial(ctx context.Context, instance string) (net.Conn, error) {
+	return f(ctx, instance)
+}
+
+// LocalDialer - ignores the instance name and connects to addr (host:port), for testing against a plain postgres
+func LocalDialer(addr string) Dialer {
+	return DialerFunc(func(ctx context.Context, instance string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, `tcp`, addr)
+	})
+}
+
+// CloudSQLOptions - Dialer is required
+// IAMAuth - the user in the connection string is an IAM principal, for service accounts use the email without
+// the ".gserviceaccount.com" suffix. TokenSource defaults to the application default credentials
+type CloudSQLOptions struct {
+	Dialer      Dialer
+	IAMAuth     bool
+	TokenSource oauth2.TokenSource
+}
+
+// NewCloudSQLDBPool - connects to the Cloud SQL instance through the dialer, which is responsible for encryption
+// expects a postgresql connection string the in the form of  "user=xxx password=xxxx dbname=xxxx", omit the password with IAMAuth
+func NewCloudSQLDBPool(instance, cparms string, o CloudSQLOptions, opts ...PoolOption) (*DBPool, error) {
+	cfg, err := cloudSQLConfig(instance, cparms, o)
+	if err != nil {
+		return nil, err
+	}
+	return connectConfig(cfg, opts)
+}
+
+func cloudSQLConfig(instance, cparms string, o CloudSQLOptions) (*pgxpool.Config, error) {
+	if len(instance) == 0 || o.Dialer == nil {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	cfg, err := pgxpool.ParseConfig(cparms)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to parse connection parameters: %v`, err)
+	}
+	cc := cfg.ConnConfig
+	// the host is only used for logging, the dialer resolves the instance itself
+	cc.Host = instance
+	cc.Fallbacks = nil
+	cc.TLSConfig = nil
+	cc.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+	d := o.Dialer
+	cc.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(ctx, instance)
+	}
+
+	if o.IAMAuth {
+		ts := o.TokenSource
+		if ts == nil {
+			if ts, err = google.DefaultTokenSource(context.Background(), IAMLoginScope); err != nil {
+				return nil, err
+			}
+		}
+		// tokens are cached and refreshed shortly before they expire
+		ts = oauth2.ReuseTokenSource(nil, ts)
+		cfg.BeforeConnect = func(ctx context.Context, c *pgx.ConnConfig) error {
+			tok, err := ts.Token()
+			if err != nil {
+				return fmt.Errorf(`unable to obtain IAM database token: %v`, err)
+			}
+			c.Password = tok.AccessToken
+			return nil
+		}
+	}
+	return cfg, nil
+}
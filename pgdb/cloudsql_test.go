@@ -0,0 +1,43 @@
+package pgdb
+
+import (
+	"context"
+	"golang.org/x/oauth2"
+	"net"
+	"testing"
+)
+
+func Test_cloudSQLConfig(t *testing.T) {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, e := ln.Accept(); e == nil {
+			_ = c.Close()
+		}
+	}()
+
+	o := CloudSQLOptions{
+		Dialer:      LocalDialer(ln.Addr().String()),
+		IAMAuth:     true,
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: `token`}),
+	}
+	cfg, err := cloudSQLConfig(`project:region:instance`, `user=sa@project.iam dbname=test`, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.BeforeConnect(context.Background(), cfg.ConnConfig); err != nil || cfg.ConnConfig.Password != `token` {
+		t.Error(`IAM token should have been used as the password`, err)
+	}
+	c, err := cfg.ConnConfig.DialFunc(context.Background(), `tcp`, `ignored:5432`)
+	if err != nil {
+		t.Fatal(`dialer should have connected to the local listener `, err)
+	}
+	_ = c.Close()
+
+	if _, err = cloudSQLConfig(`project:region:instance`, ``, CloudSQLOptions{}); err == nil {
+		t.Error(`Expected error without a dialer`)
+	}
+}
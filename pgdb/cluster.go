@@ -0,0 +1,225 @@
+package pgdb
+
+/*
+	DBCluster routes reads to read replicas and writes to the primary
+	replicas are checked in the background, those that fail or lag too far behind are skipped until they recover,
+	and reads go to the primary when no replica is available
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaSelection - how DBCluster chooses between healthy replicas
+type ReplicaSelection int
+
+const (
+	RoundRobin ReplicaSelection = iota
+	LeastConnections
+)
+
+// DefaultCheckInterval - how often replicas are checked when ClusterOptions.CheckInterval is not set
+const DefaultCheckInterval = 10 * time.Second
+
+// ClusterOptions - MaxLag of zero disables the replication lag check
+type ClusterOptions struct {
+	Selection     ReplicaSelection
+	MaxLag        time.Duration
+	CheckInterval time.Duration
+}
+
+// ReplicaStatus - outcome of the most recent check of a replica
+type ReplicaStatus struct {
+	Healthy bool
+	Lag     time.Duration
+	Checked time.Time
+	Err     error
+}
+
+// DBCluster - a primary and its read replicas
+type DBCluster struct {
+	Primary  *DBPool
+	Replicas []*DBPool
+	opts     ClusterOptions
+	next     uint32
+	mu       sync.RWMutex
+	status   []ReplicaStatus
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	closed   sync.Once
+}
+
+// NewDBCluster - replicas are assumed healthy until the first check, which starts immediately
+func NewDBCluster(primary *DBPool, replicas []*DBPool, opts ClusterOptions) (*DBCluster, error) {
+	if primary == nil {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	this := new(DBCluster)
+	this.Primary = primary
+	this.Replicas = replicas
+	this.opts = opts
+	if this.opts.CheckInterval <= 0 {
+		this.opts.CheckInterval = DefaultCheckInterval
+	}
+	this.status = make([]ReplicaStatus, len(replicas))
+	for i := range this.status {
+		this.status[i].Healthy = true
+	}
+	this.stop = make(chan struct{})
+	if len(replicas) > 0 {
+		this.stopped.Add(1)
+		go this.monitor()
+	}
+	return this, nil
+}
+
+// Close - stops the background checks and closes every pool
+func (c *DBCluster) Close() {
+	c.closed.Do(func() {
+		close(c.stop)
+		c.stopped.Wait()
+		for _, r := range c.Replicas {
+			r.Close()
+		}
+		c.Primary.Close()
+	})
+}
+
+func (c *DBCluster) monitor() {
+	defer c.stopped.Done()
+	t := time.NewTicker(c.opts.CheckInterval)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.CheckInterval)
+		c.Check(ctx)
+		cancel()
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check - checks every replica now, updating the status used for routing
+func (c *DBCluster) Check(ctx context.Context) []ReplicaStatus {
+	result := make([]ReplicaStatus, len(c.Replicas))
+	var wg sync.WaitGroup
+	for i, r := range c.Replicas {
+		wg.Add(1)
+		go func(i int, r *DBPool) {
+			defer wg.Done()
+			result[i] = c.checkReplica(ctx, r)
+		}(i, r)
+	}
+	wg.Wait()
+	c.mu.Lock()
+	copy(c.status, result)
+	c.mu.Unlock()
+	return result
+}
+
+func (c *DBCluster) checkReplica(ctx context.Context, r *DBPool) ReplicaStatus {
+	result := ReplicaStatus{Checked: time.Now()}
+	result.Lag, result.Err = r.ReplicationLag(ctx)
+	if result.Err == nil && c.opts.MaxLag > 0 && result.Lag > c.opts.MaxLag {
+		result.Err = errors.New(`replication lag ` + result.Lag.String() + ` exceeds ` + c.opts.MaxLag.String())
+	}
+	result.Healthy = result.Err == nil
+	return result
+}
+
+// Status - the most recent status of each replica, in the order given to NewDBCluster
+func (c *DBCluster) Status() []ReplicaStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ReplicaStatus(nil), c.status...)
+}
+
+type primaryKey struct{}
+
+// WithPrimary - reads made by DBCluster with the returned context go to the primary, e.g. to read your own writes
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Reader - the pool to use for a read, the primary if ctx is from WithPrimary or no replica is healthy
+func (c *DBCluster) Reader(ctx context.Context) *DBPool {
+	if ctx.Value(primaryKey{}) != nil || len(c.Replicas) == 0 {
+		return c.Primary
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n := len(c.Replicas)
+	start := int(atomic.AddUint32(&c.next, 1)) % n
+	var best *DBPool
+	var bestConns int32
+	for i := 0; i < n; i++ {
+		x := (start + i) % n
+		if !c.status[x].Healthy || !c.Replicas[x].IsConnected() {
+			continue
+		}
+		if c.opts.Selection == RoundRobin {
+			return c.Replicas[x]
+		}
+		if conns := c.Replicas[x].DBCon.Stat().AcquiredConns(); best == nil || conns < bestConns {
+			best, bestConns = c.Replicas[x], conns
+		}
+	}
+	if best == nil {
+		return c.Primary
+	}
+	return best
+}
+
+// Query - execute a sql query on a replica
+func (c *DBCluster) Query(q string, args ...interface{}) (pgx.Rows, error) {
+	return c.QueryContext(CTxt, q, args...)
+}
+
+// QueryContext - Query, cancelled when ctx is done. Use WithPrimary(ctx) to read from the primary
+func (c *DBCluster) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	return c.Reader(ctx).QueryContext(ctx, q, args...)
+}
+
+// Execute - execute a sql command on the primary (gives count of rows affected)
+func (c *DBCluster) Execute(q string, args ...interface{}) (int, error) {
+	return c.Primary.ExecuteContext(CTxt, q, args...)
+}
+
+// ExecuteContext - Execute, cancelled when ctx is done
+func (c *DBCluster) ExecuteContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	return c.Primary.ExecuteContext(ctx, q, args...)
+}
+
+// WithTx - runs fn in a transaction on the primary, see DBPool.WithTx
+func (c *DBCluster) WithTx(ctx context.Context, opts TxOptions, fn func(tx *Tx) error) error {
+	return c.Primary.WithTx(ctx, opts, fn)
+}
+
+// ReplicationLag - how far a replica is behind its primary, zero for a primary or a streaming replica that has
+// replayed everything it has received. A replica that is not streaming reports the age of the last transaction it
+// replayed, so lag keeps growing while it is disconnected. The streaming status is only visible to superusers and
+// members of pg_read_all_stats, other users always get the age, which overstates lag while the primary is idle
+func (p *DBPool) ReplicationLag(ctx context.Context) (time.Duration, error) {
+	if !p.connected || p.DBCon == nil {
+		return 0, ErrNotConnected
+	}
+	secs, err := SelectScalar[*float64](ctx, p, `SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+			AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END::float8`)
+	if err != nil {
+		return 0, err
+	}
+	if secs == nil {
+		return 0, errors.New(`replica is not streaming and has not replayed any transactions`)
+	}
+	return time.Duration(*secs * float64(time.Second)), nil
+}
@@ -0,0 +1,53 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_ClusterReader(t *testing.T) {
+	primary := &DBPool{connected: true}
+	r1, r2 := &DBPool{connected: true}, &DBPool{connected: true}
+	c := &DBCluster{Primary: primary, Replicas: []*DBPool{r1, r2}, status: []ReplicaStatus{{Healthy: true}, {Healthy: true}}}
+	ctx := context.Background()
+
+	a, b := c.Reader(ctx), c.Reader(ctx)
+	if a == b || a == primary || b == primary {
+		t.Error(`reads should alternate between replicas`)
+	}
+	if c.Reader(WithPrimary(ctx)) != primary {
+		t.Error(`WithPrimary should read from the primary`)
+	}
+	c.status[0].Healthy = false
+	for i := 0; i < 3; i++ {
+		if c.Reader(ctx) != r2 {
+			t.Error(`unhealthy replica should be skipped`)
+		}
+	}
+	r2.connected = false
+	if c.Reader(ctx) != primary {
+		t.Error(`reads should fail over to the primary`)
+	}
+}
+
+func Test_ClusterCheck(t *testing.T) {
+	c, e := NewDBCluster(&DBPool{}, []*DBPool{{}}, ClusterOptions{CheckInterval: time.Hour})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	st := c.Check(context.Background())
+	if len(st) != 1 || st[0].Healthy || !errors.Is(st[0].Err, ErrNotConnected) || c.Status()[0].Healthy {
+		t.Errorf(`unexpected status %+v`, st)
+	}
+	if c.Reader(context.Background()) != c.Primary {
+		t.Error(`reads should go to the primary`)
+	}
+	c.Close()
+	c.Close() // the deferred Close is a third call, none should panic
+	if _, e = NewDBCluster(nil, nil, ClusterOptions{}); e == nil {
+		t.Error(`Expected error without a primary`)
+	}
+}
@@ -0,0 +1,164 @@
+package pgdb
+
+/*
+	classification of errors returned by pgx, including errors wrapped with fmt.Errorf("... %w", err)
+	API handlers can use HTTPStatus to turn a database error into a response code
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ErrorKind - category of a database error
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindUniqueViolation
+	KindForeignKeyViolation
+	KindNotNullViolation
+	KindCheckViolation
+	KindSerializationFailure
+	KindDeadlock
+	KindConnectionLost
+	KindQueryCanceled
+	KindInsufficientPrivilege
+)
+
+var kindNames = map[ErrorKind]string{
+	KindUnknown:               `unknown`,
+	KindUniqueViolation:       `unique violation`,
+	KindForeignKeyViolation:   `foreign key violation`,
+	KindNotNullViolation:      `not null violation`,
+	KindCheckViolation:        `check violation`,
+	KindSerializationFailure:  `serialization failure`,
+	KindDeadlock:              `deadlock`,
+	KindConnectionLost:        `connection lost`,
+	KindQueryCanceled:         `query canceled`,
+	KindInsufficientPrivilege: `insufficient privilege`,
+}
+
+func (k ErrorKind) String() string {
+	return kindNames[k]
+}
+
+// HTTPStatus - the response code an API handler should return for this kind of error
+func (k ErrorKind) HTTPStatus() int {
+	switch k {
+	case KindUniqueViolation, KindForeignKeyViolation, KindSerializationFailure, KindDeadlock:
+		return http.StatusConflict
+	case KindNotNullViolation, KindCheckViolation:
+		return http.StatusUnprocessableEntity
+	case KindConnectionLost:
+		return http.StatusServiceUnavailable
+	case KindQueryCanceled:
+		return http.StatusGatewayTimeout
+	case KindInsufficientPrivilege:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// DBError - a classified error. Code and the names are only set for errors reported by the server
+// Schema, Table, Column and Constraint are empty when postgres does not report them for the error
+type DBError struct {
+	Kind       ErrorKind
+	Code       string
+	Message    string
+	Detail     string
+	Schema     string
+	Table      string
+	Column     string
+	Constraint string
+	Err        error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// Classify - nil if err is nil, otherwise the classified error, with KindUnknown if it fits no category
+func Classify(err error) *DBError {
+	if err == nil {
+		return nil
+	}
+	var de *DBError
+	if errors.As(err, &de) {
+		return de
+	}
+	result := &DBError{Err: err}
+	var pe *pgconn.PgError
+	if errors.As(err, &pe) {
+		result.Kind = kindOfCode(pe.Code)
+		result.Code = pe.Code
+		result.Message = pe.Message
+		result.Detail = pe.Detail
+		result.Schema = pe.SchemaName
+		result.Table = pe.TableName
+		result.Column = pe.ColumnName
+		result.Constraint = pe.ConstraintName
+		return result
+	}
+	// context.DeadlineExceeded is also a net.Error, so timeouts are checked first
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err):
+		result.Kind = KindQueryCanceled
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &ne):
+		result.Kind = KindConnectionLost
+	}
+	return result
+}
+
+func kindOfCode(code string) ErrorKind {
+	switch code {
+	case pgerrcode.UniqueViolation:
+		return KindUniqueViolation
+	case pgerrcode.ForeignKeyViolation:
+		return KindForeignKeyViolation
+	case pgerrcode.NotNullViolation:
+		return KindNotNullViolation
+	case pgerrcode.CheckViolation:
+		return KindCheckViolation
+	case pgerrcode.SerializationFailure:
+		return KindSerializationFailure
+	case pgerrcode.DeadlockDetected:
+		return KindDeadlock
+	case pgerrcode.QueryCanceled:
+		return KindQueryCanceled
+	case pgerrcode.InsufficientPrivilege:
+		return KindInsufficientPrivilege
+	case pgerrcode.AdminShutdown, pgerrcode.CrashShutdown, pgerrcode.CannotConnectNow:
+		return KindConnectionLost
+	}
+	if pgerrcode.IsConnectionException(code) {
+		return KindConnectionLost
+	}
+	return KindUnknown
+}
+
+// KindOf - the category of err, KindUnknown if err is nil
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+	return Classify(err).Kind
+}
+
+// HTTPStatus - http.StatusOK if err is nil, otherwise the response code for the kind of error
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return KindOf(err).HTTPStatus()
+}
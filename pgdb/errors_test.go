@@ -0,0 +1,58 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func Test_Classify(t *testing.T) {
+	pe := &pgconn.PgError{Code: pgerrcode.UniqueViolation, TableName: `users`, ConstraintName: `users_email_key`}
+	wrapped := fmt.Errorf(`insert user: %w`, pe)
+	de := Classify(wrapped)
+	if de.Kind != KindUniqueViolation || de.Table != `users` || de.Constraint != `users_email_key` || de.Code != pe.Code {
+		t.Errorf(`unexpected classification %+v`, de)
+	}
+	if !errors.Is(de, pe) || Classify(de) != de {
+		t.Error(`DBError should wrap the original error`)
+	}
+	if !IsDuplicate(wrapped) || IsForeignKeyConstraint(wrapped) {
+		t.Error(`IsDuplicate should see through wrapping`)
+	}
+	if Classify(nil) != nil || KindOf(nil) != KindUnknown || HTTPStatus(nil) != http.StatusOK {
+		t.Error(`unexpected result for nil error`)
+	}
+
+	tests := []struct {
+		err    error
+		kind   ErrorKind
+		status int
+	}{
+		{&pgconn.PgError{Code: pgerrcode.ForeignKeyViolation}, KindForeignKeyViolation, http.StatusConflict},
+		{&pgconn.PgError{Code: pgerrcode.NotNullViolation, ColumnName: `name`}, KindNotNullViolation, http.StatusUnprocessableEntity},
+		{&pgconn.PgError{Code: pgerrcode.CheckViolation}, KindCheckViolation, http.StatusUnprocessableEntity},
+		{&pgconn.PgError{Code: pgerrcode.SerializationFailure}, KindSerializationFailure, http.StatusConflict},
+		{&pgconn.PgError{Code: pgerrcode.DeadlockDetected}, KindDeadlock, http.StatusConflict},
+		{&pgconn.PgError{Code: pgerrcode.AdminShutdown}, KindConnectionLost, http.StatusServiceUnavailable},
+		{&pgconn.PgError{Code: pgerrcode.ConnectionFailure}, KindConnectionLost, http.StatusServiceUnavailable},
+		{&pgconn.PgError{Code: pgerrcode.QueryCanceled}, KindQueryCanceled, http.StatusGatewayTimeout},
+		{&pgconn.PgError{Code: pgerrcode.InsufficientPrivilege}, KindInsufficientPrivilege, http.StatusForbidden},
+		{&pgconn.PgError{Code: pgerrcode.SyntaxError}, KindUnknown, http.StatusInternalServerError},
+		{fmt.Errorf(`read: %w`, io.ErrUnexpectedEOF), KindConnectionLost, http.StatusServiceUnavailable},
+		{context.DeadlineExceeded, KindQueryCanceled, http.StatusGatewayTimeout},
+		{errors.New(`other`), KindUnknown, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if k := KindOf(tt.err); k != tt.kind {
+			t.Errorf(`KindOf(%v) = %v, want %v`, tt.err, k, tt.kind)
+		}
+		if s := HTTPStatus(tt.err); s != tt.status {
+			t.Errorf(`HTTPStatus(%v) = %d, want %d`, tt.err, s, tt.status)
+		}
+	}
+}
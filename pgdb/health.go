@@ -0,0 +1,123 @@
+package pgdb
+
+/*
+	health checks and connection statistics for DBPool
+	IsConnected only reports whether the pool was opened, Ping and Health talk to the database
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrNotConnected - the pool was never opened, or has been closed
+var ErrNotConnected = errors.New(`not connected`)
+
+// Ping - acquires a connection and checks the database responds
+func (p *DBPool) Ping(ctx context.Context) error {
+	if !p.connected || p.DBCon == nil {
+		return ErrNotConnected
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return p.DBCon.Ping(ctx)
+}
+
+// ErrHealthCheckFailed - the message reported by Health when the database could not be queried
+// the report is served to unauthenticated probes, so the underlying error is only in HealthReport.Err and the pool's log
+var ErrHealthCheckFailed = errors.New(`health check failed`)
+
+// HealthReport - result of DBPool.Health, Error is empty when the database is healthy
+// InRecovery is true for a read replica, or a primary that is still recovering
+type HealthReport struct {
+	Healthy       bool          `json:"healthy"`
+	Latency       time.Duration `json:"latency"`
+	ServerVersion string        `json:"serverVersion,omitempty"`
+	InRecovery    bool          `json:"inRecovery"`
+	Error         string        `json:"error,omitempty"`
+	Stats         PoolStats     `json:"stats"`
+	Err           error         `json:"-"`
+}
+
+// Health - queries the server version and recovery status, Latency is the round trip time of the query
+func (p *DBPool) Health(ctx context.Context) HealthReport {
+	var result HealthReport
+	if !p.connected || p.DBCon == nil {
+		result.Err = ErrNotConnected
+		result.Error = ErrNotConnected.Error()
+		return result
+	}
+	result.Stats = p.Stats()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	err := p.DBCon.QueryRow(ctx, `SELECT current_setting('server_version'), pg_is_in_recovery()`).
+		Scan(&result.ServerVersion, &result.InRecovery)
+	result.Latency = time.Since(start)
+	if err != nil {
+		p.logWarn(`health check failed`, err)
+		result.Err = err
+		result.Error = ErrHealthCheckFailed.Error()
+		return result
+	}
+	result.Healthy = true
+	return result
+}
+
+// PoolStats - snapshot of the connection pool
+// AcquireDuration is the total time spent waiting for connections, EmptyAcquireCount the number of
+// acquires that had to wait because no idle connection was available
+type PoolStats struct {
+	AcquiredConns        int32         `json:"acquiredConns"`
+	IdleConns            int32         `json:"idleConns"`
+	ConstructingConns    int32         `json:"constructingConns"`
+	TotalConns           int32         `json:"totalConns"`
+	MaxConns             int32         `json:"maxConns"`
+	AcquireCount         int64         `json:"acquireCount"`
+	EmptyAcquireCount    int64         `json:"emptyAcquireCount"`
+	CanceledAcquireCount int64         `json:"canceledAcquireCount"`
+	AcquireDuration      time.Duration `json:"acquireDuration"`
+}
+
+// Stats - zero if the pool is not connected
+func (p *DBPool) Stats() PoolStats {
+	if !p.connected || p.DBCon == nil {
+		return PoolStats{}
+	}
+	s := p.DBCon.Stat()
+	return PoolStats{
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		TotalConns:           s.TotalConns(),
+		MaxConns:             s.MaxConns(),
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireDuration:      s.AcquireDuration(),
+	}
+}
+
+// ReadinessHandler - responds with the HealthReport as json, status 200 when healthy and 503 otherwise
+// each check is limited to timeout, which defaults to 2 seconds
+func (p *DBPool) ReadinessHandler(timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		report := p.Health(ctx)
+		w.Header().Set(`Content-Type`, `application/json`)
+		w.Header().Set(`Cache-Control`, `no-store`)
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,68 @@
+package pgdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_HealthNotConnected(t *testing.T) {
+	p := new(DBPool)
+	if e := p.Ping(context.Background()); !errors.Is(e, ErrNotConnected) {
+		t.Errorf(`Expected ErrNotConnected, got %v`, e)
+	}
+	if p.Stats() != (PoolStats{}) {
+		t.Error(`Expected empty stats`)
+	}
+	rec := httptest.NewRecorder()
+	p.ReadinessHandler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/ready`, nil))
+	var r HealthReport
+	if rec.Code != http.StatusServiceUnavailable || json.Unmarshal(rec.Body.Bytes(), &r) != nil || r.Healthy || r.Error == `` {
+		t.Errorf(`unexpected readiness response %d %s`, rec.Code, rec.Body.String())
+	}
+}
+
+func Test_HealthUnreachable(t *testing.T) {
+	cfg, _ := pgxpool.ParseConfig(`host=127.0.0.1 port=1 dbname=test connect_timeout=1`)
+	cfg.LazyConnect = true
+	db, e := pgxpool.ConnectConfig(context.Background(), cfg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p := &DBPool{DBCon: db, connected: true}
+	defer p.Close()
+	rec := httptest.NewRecorder()
+	p.ReadinessHandler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/ready`, nil))
+	var r HealthReport
+	if rec.Code != http.StatusServiceUnavailable || json.Unmarshal(rec.Body.Bytes(), &r) != nil || r.Error != ErrHealthCheckFailed.Error() {
+		t.Errorf(`unexpected readiness response %d %s`, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), `127.0.0.1`) {
+		t.Errorf(`driver error exposed in readiness response %s`, rec.Body.String())
+	}
+	if h := p.Health(context.Background()); h.Err == nil || h.Err == ErrHealthCheckFailed {
+		t.Errorf(`Expected the underlying error in Err, got %v`, h.Err)
+	}
+}
+
+func Test_Health(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	if e := p.Ping(context.Background()); e != nil {
+		t.Error(e)
+	}
+	h := p.Health(context.Background())
+	if !h.Healthy || h.ServerVersion == `` || h.Latency <= 0 || h.Stats.MaxConns != maxConnections {
+		t.Errorf(`unexpected health report %+v`, h)
+	}
+	rec := httptest.NewRecorder()
+	p.ReadinessHandler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/ready`, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf(`unexpected readiness status %d`, rec.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package pgdb
+
+/*
+	lease based leader election
+	each election is a row in the leases table, held by one instance until its lease expires. The leader renews the
+	lease well before expiry, other instances take it over once it lapses. Lease times use the database clock, so
+	instances do not need synchronised clocks. Unlike an advisory lock no connection is held between renewals,
+	which suits instances whose CPU is throttled between requests (e.g. Cloud Run)
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTable - table holding leases when ElectorOptions.Table is not set
+const DefaultLeaseTable = `leader_leases`
+
+// DefaultLeaseDuration - lease length when ElectorOptions.LeaseDuration is not set
+const DefaultLeaseDuration = 30 * time.Second
+
+// ElectorOptions - all fields are optional
+// Identity - distinguishes this instance, defaults to hostname-pid-random
+// RenewInterval - how often the lease is renewed, or its acquisition attempted, defaults to a third of LeaseDuration
+// OnChange - called from the goroutine running Run each time this instance gains or loses leadership
+type ElectorOptions struct {
+	Table         string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewInterval time.Duration
+	OnChange      func(leader bool)
+}
+
+// LeaderElector - campaigns for leadership of a named election
+type LeaderElector struct {
+	pool   *DBPool
+	name   string
+	opts   ElectorOptions
+	mu     sync.RWMutex
+	leader bool
+	// the time of the last successful acquire or renew, taken before the statement was sent
+	renewed time.Time
+}
+
+// NewLeaderElector - instances using the same name compete for the same leadership
+func NewLeaderElector(p *DBPool, name string, opts ElectorOptions) (*LeaderElector, error) {
+	if p == nil || len(name) == 0 {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	this := new(LeaderElector)
+	this.pool = p
+	this.name = name
+	this.opts = opts
+	if len(this.opts.Table) == 0 {
+		this.opts.Table = DefaultLeaseTable
+	}
+	if this.opts.LeaseDuration <= 0 {
+		this.opts.LeaseDuration = DefaultLeaseDuration
+	}
+	if this.opts.RenewInterval <= 0 {
+		this.opts.RenewInterval = this.opts.LeaseDuration / 3
+	}
+	if this.opts.RenewInterval >= this.opts.LeaseDuration {
+		return nil, errors.New(`renew interval must be shorter than the lease duration`)
+	}
+	if len(this.opts.Identity) == 0 {
+		this.opts.Identity = defaultIdentity()
+	}
+	return this, nil
+}
+
+func defaultIdentity() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf(`%s-%d-%s`, host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// Identity - the identity this instance holds the lease under
+func (e *LeaderElector) Identity() string {
+	return e.opts.Identity
+}
+
+// IsLeader - true while this instance holds an unexpired lease
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader && time.Since(e.renewed) < e.opts.LeaseDuration
+}
+
+// Run - campaigns and renews until ctx is done, then gives up the lease so another instance can take over at once
+// errors renewing the lease are retried, leadership is given up if the lease expires before a renewal succeeds
+func (e *LeaderElector) Run(ctx context.Context) error {
+	if _, err := e.pool.ExecuteContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name text PRIMARY KEY,
+		holder text NOT NULL,
+		expires_at timestamptz NOT NULL)`, e.table())); err != nil {
+		return err
+	}
+	t := time.NewTicker(e.opts.RenewInterval)
+	defer t.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// campaign - acquires or renews the lease
+func (e *LeaderElector) campaign(ctx context.Context) {
+	start := time.Now()
+	ok, err := e.tryAcquire(ctx)
+	switch {
+	case err == nil:
+		e.setLeader(ok, start)
+	case time.Since(e.lastRenewed()) >= e.opts.LeaseDuration:
+		e.setLeader(false, time.Time{})
+	}
+	if err != nil && ctx.Err() == nil {
+		e.pool.logWarn(`leader lease renewal failed`, err)
+	}
+}
+
+// tryAcquire - takes the lease if it is free or expired, or extends it if already held
+func (e *LeaderElector) tryAcquire(ctx context.Context) (bool, error) {
+	q := fmt.Sprintf(`INSERT INTO %[1]s AS l (name, holder, expires_at)
+		VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE l.holder = EXCLUDED.holder OR l.expires_at < now()
+		RETURNING holder`, e.table())
+	_, err := SelectScalar[string](ctx, e.pool, q, e.name, e.opts.Identity, e.opts.LeaseDuration.Milliseconds())
+	if errors.Is(err, ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// resign - releases the lease if held, uses a fresh context as the one given to Run is done
+func (e *LeaderElector) resign() {
+	if e.IsLeader() {
+		ctx, cancel := context.WithTimeout(context.Background(), e.opts.RenewInterval)
+		defer cancel()
+		_, _ = e.pool.ExecuteContext(ctx, `DELETE FROM `+e.table()+` WHERE name = $1 AND holder = $2`, e.name, e.opts.Identity)
+	}
+	e.setLeader(false, time.Time{})
+}
+
+func (e *LeaderElector) setLeader(leader bool, renewed time.Time) {
+	e.mu.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	if leader {
+		e.renewed = renewed
+	}
+	e.mu.Unlock()
+	if changed && e.opts.OnChange != nil {
+		e.opts.OnChange(leader)
+	}
+}
+
+func (e *LeaderElector) lastRenewed() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.renewed
+}
+
+func (e *LeaderElector) table() string {
+	return identifier(e.opts.Table)
+}
@@ -0,0 +1,64 @@
+package pgdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_NewLeaderElector(t *testing.T) {
+	if _, e := NewLeaderElector(nil, `x`, ElectorOptions{}); e == nil {
+		t.Error(`Expected error without a pool`)
+	}
+	if _, e := NewLeaderElector(new(DBPool), `x`, ElectorOptions{LeaseDuration: time.Second, RenewInterval: time.Second}); e == nil {
+		t.Error(`Expected error for renew interval not shorter than the lease`)
+	}
+	var changes []bool
+	le, e := NewLeaderElector(new(DBPool), `x`, ElectorOptions{OnChange: func(l bool) { changes = append(changes, l) }})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if le.opts.Table != DefaultLeaseTable || le.opts.RenewInterval != DefaultLeaseDuration/3 || le.Identity() == `` {
+		t.Errorf(`unexpected defaults %+v`, le.opts)
+	}
+	le.setLeader(true, time.Now())
+	le.setLeader(true, time.Now())
+	if !le.IsLeader() {
+		t.Error(`should be leader`)
+	}
+	le.setLeader(true, time.Now().Add(-DefaultLeaseDuration))
+	if le.IsLeader() {
+		t.Error(`expired lease should not be leader`)
+	}
+	le.setLeader(false, time.Time{})
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf(`unexpected changes %v`, changes)
+	}
+}
+
+func Test_LeaderElector(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	defer p.Execute(`drop table if exists leader_test`)
+	opts := ElectorOptions{Table: `leader_test`, LeaseDuration: 3 * time.Second}
+	a, _ := NewLeaderElector(p, `job`, opts)
+	b, _ := NewLeaderElector(p, `job`, opts)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan error)
+	go func() { doneA <- a.Run(ctxA) }()
+	time.Sleep(500 * time.Millisecond)
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.Run(ctxB)
+	time.Sleep(500 * time.Millisecond)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Error(`first elector should lead`)
+	}
+	cancelA()
+	<-doneA
+	time.Sleep(1500 * time.Millisecond)
+	if a.IsLeader() || !b.IsLeader() {
+		t.Error(`second elector should take over`)
+	}
+}
@@ -0,0 +1,357 @@
+package pgdb
+
+/*
+	LISTEN / NOTIFY support
+	all subscriptions of a DBPool share one dedicated connection, opened with the pool's configuration but not counted
+	against its size. If the connection drops it is reopened and every channel is listened to again.
+	DBPool.Close stops the listener and closes its connection
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sync"
+	"time"
+)
+
+const (
+	listenTimeout   = 30 * time.Second
+	listenMaxDelay  = 30 * time.Second
+	listenBaseDelay = 100 * time.Millisecond
+)
+
+// Notification - a notification received on a channel
+// Reconnected is set, with no payload, when the listening connection has been reopened. Notifications sent
+// while it was down are lost, so handlers caching data should treat this as "anything may have changed"
+type Notification struct {
+	Channel     string
+	Payload     string
+	PID         uint32
+	Reconnected bool
+}
+
+// Decode - unmarshals the json payload into v
+func (n Notification) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(n.Payload), v)
+}
+
+// NotificationHandler - called for each notification in the order received, handlers for all channels
+// are called from a single goroutine so should return quickly, and must not call DBPool.Close
+type NotificationHandler func(ctx context.Context, n Notification)
+
+// Listen - handler receives notifications sent to channel until ctx is done
+// returns once the channel is being listened to, Listen may be called any number of times for the same or other channels
+func (p *DBPool) Listen(ctx context.Context, channel string, handler NotificationHandler) error {
+	if !p.connected || p.DBCon == nil {
+		return ErrNotConnected
+	}
+	if len(channel) == 0 || handler == nil {
+		return errors.New(`invalid parameter(s)`)
+	}
+	p.listenOnce.Do(func() {
+		p.lsn = newListener(p.DBCon.Config())
+	})
+	if p.lsn == nil {
+		return ErrNotConnected // closed before the first call to Listen
+	}
+	s := &subscription{channel: channel, handler: handler, ctx: ctx, ready: make(chan error, 1)}
+	p.lsn.add(s)
+	select {
+	case err := <-s.ready:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		p.lsn.remove(s)
+		return ctx.Err()
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.lsn.remove(s)
+		case <-p.lsn.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+// Notify - sends payload to channel. Strings and byte slices are sent as they are, anything else as json
+func (p *DBPool) Notify(channel string, payload interface{}) error {
+	return p.NotifyContext(CTxt, channel, payload)
+}
+
+// NotifyContext - Notify, cancelled when ctx is done
+func (p *DBPool) NotifyContext(ctx context.Context, channel string, payload interface{}) error {
+	msg, err := notifyPayload(payload)
+	if err != nil {
+		return err
+	}
+	_, err = p.ExecuteContext(ctx, `SELECT pg_notify($1, $2)`, channel, msg)
+	return err
+}
+
+func notifyPayload(payload interface{}) (string, error) {
+	switch v := payload.(type) {
+	case nil:
+		return ``, nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	b, err := json.Marshal(payload)
+	return string(b), err
+}
+
+type subscription struct {
+	channel string
+	handler NotificationHandler
+	ctx     context.Context
+	ready   chan error
+}
+
+type listener struct {
+	// copied from the pool when the listener is created, so reconnecting does not depend on the pool being open
+	cfg     *pgxpool.Config
+	mu      sync.Mutex
+	subs    map[string][]*subscription
+	pending []*subscription
+	running bool
+	// interrupts the current wait so that changes to subs are picked up
+	wake context.CancelFunc
+	// cancelled by close, which then waits for run to exit
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+	exited sync.WaitGroup
+}
+
+func newListener(cfg *pgxpool.Config) *listener {
+	this := new(listener)
+	this.cfg = cfg
+	this.subs = make(map[string][]*subscription)
+	this.ctx, this.cancel = context.WithCancel(context.Background())
+	return this
+}
+
+func (l *listener) add(s *subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		s.ready <- ErrNotConnected
+		return
+	}
+	l.subs[s.channel] = append(l.subs[s.channel], s)
+	l.pending = append(l.pending, s)
+	if !l.running {
+		l.running = true
+		l.exited.Add(1)
+		go l.run()
+	} else if l.wake != nil {
+		l.wake()
+	}
+}
+
+// close - stops run, returning once the connection has been closed
+func (l *listener) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.cancel()
+	l.exited.Wait()
+}
+
+func (l *listener) remove(s *subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := l.subs[s.channel]
+	for i, x := range list {
+		if x == s {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(l.subs, s.channel)
+	} else {
+		l.subs[s.channel] = list
+	}
+	if l.wake != nil {
+		l.wake()
+	}
+}
+
+// handlers - a copy of the subscriptions to channel, or to every channel if channel is empty
+func (l *listener) handlers(channel string) []*subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(channel) > 0 {
+		return append([]*subscription(nil), l.subs[channel]...)
+	}
+	var result []*subscription
+	for _, list := range l.subs {
+		result = append(result, list...)
+	}
+	return result
+}
+
+// run - owns the connection, exits when there are no subscriptions left or the listener is closed
+func (l *listener) run() {
+	defer l.exited.Done()
+	var conn *pgx.Conn
+	listening := make(map[string]bool)
+	reconnected := false
+	var delay time.Duration
+	defer func() {
+		if conn != nil {
+			_ = conn.Close(context.Background())
+		}
+	}()
+	for {
+		l.mu.Lock()
+		if len(l.subs) == 0 || l.closed {
+			l.running = false
+			l.wake = nil
+			err := context.Canceled
+			if l.closed {
+				err = ErrNotConnected
+			}
+			for _, s := range l.pending {
+				s.ready <- err
+			}
+			l.pending = nil
+			l.mu.Unlock()
+			return
+		}
+		want := make(map[string]bool, len(l.subs))
+		for ch := range l.subs {
+			want[ch] = true
+		}
+		pending := l.pending
+		l.pending = nil
+		wait, cancel := context.WithCancel(l.ctx)
+		l.wake = cancel
+		l.mu.Unlock()
+
+		err := l.prepare(&conn, listening, want)
+		if err == nil {
+			for _, s := range pending {
+				s.ready <- nil
+			}
+			if reconnected {
+				reconnected = false
+				for _, s := range l.handlers(``) {
+					s.handler(s.ctx, Notification{Channel: s.channel, Reconnected: true})
+				}
+			}
+			var n *pgconn.Notification
+			n, err = conn.WaitForNotification(wait)
+			if err == nil {
+				delay = 0
+				for _, s := range l.handlers(n.Channel) {
+					s.handler(s.ctx, Notification{Channel: n.Channel, Payload: n.Payload, PID: n.PID})
+				}
+			} else if wait.Err() != nil {
+				// woken by a change to the subscriptions, the connection is still usable
+				err = nil
+			}
+		} else {
+			for _, s := range pending {
+				s.ready <- err
+				l.remove(s)
+			}
+		}
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		// the connection is broken, reopen it after a growing delay
+		if conn != nil {
+			_ = conn.Close(context.Background())
+			conn = nil
+			reconnected = len(listening) > 0
+			listening = make(map[string]bool)
+		}
+		logWarn(l.cfg.ConnConfig, `listen connection failed`, err)
+		if delay = delay * 2; delay < listenBaseDelay {
+			delay = listenBaseDelay
+		} else if delay > listenMaxDelay {
+			delay = listenMaxDelay
+		}
+		l.mu.Lock()
+		sleep, cancel := context.WithTimeout(l.ctx, delay)
+		l.wake = cancel
+		l.mu.Unlock()
+		<-sleep.Done()
+		cancel()
+	}
+}
+
+// prepare - opens the connection if required and brings the channels listened to in line with want
+func (l *listener) prepare(conn **pgx.Conn, listening, want map[string]bool) error {
+	ctx, cancel := context.WithTimeout(l.ctx, listenTimeout)
+	defer cancel()
+	if *conn == nil {
+		c, err := connectDedicated(ctx, l.cfg)
+		if err != nil {
+			return err
+		}
+		*conn = c
+	}
+	for ch := range want {
+		if !listening[ch] {
+			if _, err := (*conn).Exec(ctx, `LISTEN `+pgx.Identifier{ch}.Sanitize()); err != nil {
+				return err
+			}
+			listening[ch] = true
+		}
+	}
+	for ch := range listening {
+		if !want[ch] {
+			if _, err := (*conn).Exec(ctx, `UNLISTEN `+pgx.Identifier{ch}.Sanitize()); err != nil {
+				return err
+			}
+			delete(listening, ch)
+		}
+	}
+	return nil
+}
+
+// connectDedicated - a connection outside of the pool, configured in the same way as the pool's connections
+func connectDedicated(ctx context.Context, cfg *pgxpool.Config) (*pgx.Conn, error) {
+	cc := cfg.ConnConfig.Copy()
+	if cfg.BeforeConnect != nil {
+		if err := cfg.BeforeConnect(ctx, cc); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := pgx.ConnectConfig(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AfterConnect != nil {
+		if err = cfg.AfterConnect(ctx, conn); err != nil {
+			_ = conn.Close(ctx)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// logWarn - logs to the pool's pgx logger, if one was configured with WithLogger / WithLogrus
+func (p *DBPool) logWarn(msg string, err error) {
+	if db := p.DBCon; db != nil {
+		logWarn(db.Config().ConnConfig, msg, err)
+	}
+}
+
+func logWarn(cc *pgx.ConnConfig, msg string, err error) {
+	if cc.Logger != nil && cc.LogLevel >= pgx.LogLevelWarn {
+		cc.Logger.Log(context.Background(), pgx.LogLevelWarn, msg, map[string]interface{}{`err`: err})
+	}
+}
@@ -0,0 +1,90 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"testing"
+	"time"
+)
+
+func Test_notifyPayload(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, ``},
+		{`plain`, `plain`},
+		{[]byte(`bytes`), `bytes`},
+		{map[string]int{`id`: 7}, `{"id":7}`},
+	}
+	for _, tt := range tests {
+		if got, e := notifyPayload(tt.in); e != nil || got != tt.want {
+			t.Errorf(`notifyPayload(%v) = %s, %v`, tt.in, got, e)
+		}
+	}
+	var v struct{ ID int }
+	if e := (Notification{Payload: `{"id":7}`}).Decode(&v); e != nil || v.ID != 7 {
+		t.Error(`Decode failed`, v, e)
+	}
+	if e := new(DBPool).Listen(context.Background(), `c`, func(context.Context, Notification) {}); !errors.Is(e, ErrNotConnected) {
+		t.Errorf(`Expected ErrNotConnected, got %v`, e)
+	}
+}
+
+func Test_listenerClose(t *testing.T) {
+	cfg, e := pgxpool.ParseConfig(`host=127.0.0.1 port=1 dbname=test connect_timeout=1`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p := &DBPool{}
+	p.listenOnce.Do(func() { p.lsn = newListener(cfg) })
+	// an established subscription, so the listener keeps retrying the unreachable server
+	p.lsn.subs[`c`] = []*subscription{{channel: `c`, handler: func(context.Context, Notification) {}, ctx: context.Background()}}
+	p.lsn.running = true
+	p.lsn.exited.Add(1)
+	go p.lsn.run()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		p.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`Close did not stop the listener`)
+	}
+	if e = p.Listen(context.Background(), `c`, func(context.Context, Notification) {}); !errors.Is(e, ErrNotConnected) {
+		t.Errorf(`Expected ErrNotConnected after Close, got %v`, e)
+	}
+}
+
+func Test_Listen(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan Notification, 4)
+	handler := func(ctx context.Context, n Notification) { got <- n }
+	if e := p.Listen(ctx, `listen_test_a`, handler); e != nil {
+		t.Fatal(e)
+	}
+	if e := p.Listen(ctx, `listen_test_b`, handler); e != nil {
+		t.Fatal(e)
+	}
+	if e := p.Notify(`listen_test_b`, map[string]int{`id`: 1}); e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case n := <-got:
+		var v struct{ ID int }
+		if n.Channel != `listen_test_b` || n.Decode(&v) != nil || v.ID != 1 {
+			t.Errorf(`unexpected notification %+v`, n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error(`notification not received`)
+	}
+}
@@ -0,0 +1,123 @@
+package pgdb
+
+/*
+	postgres advisory locks
+	session level locks are held on a connection taken from the pool for the duration of the work,
+	transaction level locks (Tx.AdvisoryLock) are released automatically when the transaction ends
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"hash/fnv"
+)
+
+// LockKey - identifies an advisory lock, every application sharing the database must agree on the keys used
+type LockKey int64
+
+// StringLockKey - a key derived from name, a 64 bit FNV-1a hash, so distinct names are very unlikely to collide
+func StringLockKey(name string) LockKey {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return LockKey(h.Sum64())
+}
+
+// ErrLockNotAcquired - returned by lock helpers that do not wait when another session holds the lock
+var ErrLockNotAcquired = errors.New(`advisory lock is held by another session`)
+
+// LockedConn - the pool connection holding a session level advisory lock, valid until the lock helper returns
+type LockedConn struct {
+	conn *pgxpool.Conn
+	obs  *observer
+}
+
+// PgxConn - the underlying pool connection
+func (c *LockedConn) PgxConn() *pgxpool.Conn {
+	return c.conn
+}
+
+// QueryContext - execute a sql query on the locked connection
+func (c *LockedConn) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	return query(ctx, c.conn, c.obs, func() {}, q, args)
+}
+
+// ExecuteContext - execute a sql command on the locked connection (gives count of rows affected)
+func (c *LockedConn) ExecuteContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	return execute(ctx, c.conn, c.obs, q, args)
+}
+
+// WithAdvisoryLock - waits for the session level lock on key, runs fn and releases the lock
+// the lock holds a pool connection while fn runs, which fn should use for its statements. Statements fn runs on
+// the pool need a second connection, so can wait forever when the pool is exhausted, e.g. with WithMaxConns(1).
+// If the connection holding the lock fails, the lock is released by the server while fn is still running, so fn
+// must not rely on it for correctness of the data it writes
+func (p *DBPool) WithAdvisoryLock(ctx context.Context, key LockKey, fn func(ctx context.Context, conn *LockedConn) error) error {
+	conn, err := p.lockConn(ctx, key, false)
+	if err != nil {
+		return err
+	}
+	defer p.unlockConn(conn, key)
+	return fn(ctx, &LockedConn{conn: conn, obs: p.obs})
+}
+
+// TryWithAdvisoryLock - WithAdvisoryLock, but returns false without running fn if the lock is held elsewhere
+func (p *DBPool) TryWithAdvisoryLock(ctx context.Context, key LockKey, fn func(ctx context.Context, conn *LockedConn) error) (bool, error) {
+	conn, err := p.lockConn(ctx, key, true)
+	if errors.Is(err, ErrLockNotAcquired) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer p.unlockConn(conn, key)
+	return true, fn(ctx, &LockedConn{conn: conn, obs: p.obs})
+}
+
+// lockConn - a connection holding the session level lock on key, which must be released with unlockConn
+// when try is set ErrLockNotAcquired is returned instead of waiting
+func (p *DBPool) lockConn(ctx context.Context, key LockKey, try bool) (*pgxpool.Conn, error) {
+	if !p.connected || p.DBCon == nil {
+		return nil, ErrNotConnected
+	}
+	conn, err := p.DBCon.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := observed{db: conn, obs: p.obs}
+	if try {
+		var ok bool
+		if ok, err = SelectScalar[bool](ctx, db, `SELECT pg_try_advisory_lock($1)`, int64(key)); err == nil && !ok {
+			err = ErrLockNotAcquired
+		}
+	} else {
+		_, err = db.ExecuteContext(ctx, `SELECT pg_advisory_lock($1)`, int64(key))
+	}
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// unlockConn - releases the lock and returns the connection to the pool
+// if the unlock fails the connection is closed instead, which also releases the lock
+func (p *DBPool) unlockConn(conn *pgxpool.Conn, key LockKey) {
+	db := observed{db: conn, obs: p.obs}
+	if _, err := db.ExecuteContext(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(key)); err != nil {
+		_ = conn.Conn().Close(context.Background())
+	}
+	conn.Release()
+}
+
+// AdvisoryLock - waits for the transaction level lock on key, held until the transaction commits or rolls back
+func (t *Tx) AdvisoryLock(ctx context.Context, key LockKey) error {
+	_, err := t.ExecuteContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(key))
+	return err
+}
+
+// TryAdvisoryLock - AdvisoryLock, but returns false immediately if the lock is held elsewhere
+func (t *Tx) TryAdvisoryLock(ctx context.Context, key LockKey) (bool, error) {
+	return SelectScalar[bool](ctx, t, `SELECT pg_try_advisory_xact_lock($1)`, int64(key))
+}
@@ -0,0 +1,72 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_StringLockKey(t *testing.T) {
+	if StringLockKey(`nightly`) != StringLockKey(`nightly`) || StringLockKey(`nightly`) == StringLockKey(`hourly`) {
+		t.Error(`keys should be deterministic and distinct`)
+	}
+	if e := new(DBPool).WithAdvisoryLock(context.Background(), 1, nil); !errors.Is(e, ErrNotConnected) {
+		t.Errorf(`Expected ErrNotConnected, got %v`, e)
+	}
+}
+
+func Test_AdvisoryLock(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	key := StringLockKey(`lock_test`)
+	ran := false
+	e := p.WithAdvisoryLock(ctx, key, func(ctx context.Context, conn *LockedConn) error {
+		if n, e := SelectScalar[int](ctx, conn, `SELECT 1`); e != nil || n != 1 {
+			t.Error(`query on the locked connection failed`, n, e)
+		}
+		ok, e := p.TryWithAdvisoryLock(ctx, key, func(ctx context.Context, conn *LockedConn) error {
+			t.Error(`lock should not be acquired while held`)
+			return nil
+		})
+		if ok || e != nil {
+			t.Error(`TryWithAdvisoryLock should fail without error`, ok, e)
+		}
+		ran = true
+		return nil
+	})
+	if e != nil || !ran {
+		t.Error(`WithAdvisoryLock failed`, e)
+	}
+	if ok, e := p.TryWithAdvisoryLock(ctx, key, func(ctx context.Context, conn *LockedConn) error { return nil }); !ok || e != nil {
+		t.Error(`lock should be free`, ok, e)
+	}
+	e = p.WithTx(ctx, TxOptions{}, func(tx *Tx) error {
+		if e := tx.AdvisoryLock(ctx, key); e != nil {
+			return e
+		}
+		ok, e := tx.TryAdvisoryLock(ctx, key)
+		if !ok {
+			t.Error(`transaction lock should be re-entrant`)
+		}
+		return e
+	})
+	if e != nil {
+		t.Error(e)
+	}
+}
+
+func Test_AdvisoryLockSingleConn(t *testing.T) {
+	p := testPool(t, WithMaxConns(1))
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	e := p.WithAdvisoryLock(ctx, StringLockKey(`lock_test_single`), func(ctx context.Context, conn *LockedConn) error {
+		_, e := conn.ExecuteContext(ctx, `SELECT 1`)
+		return e
+	})
+	if e != nil {
+		t.Error(`statements on the locked connection should not need a second connection`, e)
+	}
+}
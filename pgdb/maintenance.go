@@ -0,0 +1,226 @@
+package pgdb
+
+/*
+	targeted maintenance and inspection, as lighter alternatives to Optimize
+	the reports read the postgres statistics views, which only cover objects the connected user can see
+	maintenance statements use the pool statement timeout, give them a context with a deadline to allow longer
+*/
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// VacuumOptions - Analyze also updates planner statistics. Full rewrites the table to return space to the
+// operating system, but locks it exclusively while doing so
+type VacuumOptions struct {
+	Analyze bool
+	Full    bool
+	Freeze  bool
+}
+
+// Vacuum - vacuums a single table, which may be schema qualified
+func (p *DBPool) Vacuum(ctx context.Context, table string, opts VacuumOptions) error {
+	_, err := p.ExecuteContext(ctx, vacuumSQL(table, opts))
+	return err
+}
+
+func vacuumSQL(table string, opts VacuumOptions) string {
+	var flags []string
+	if opts.Full {
+		flags = append(flags, `FULL`)
+	}
+	if opts.Freeze {
+		flags = append(flags, `FREEZE`)
+	}
+	if opts.Analyze {
+		flags = append(flags, `ANALYZE`)
+	}
+	if len(flags) == 0 {
+		return `VACUUM ` + identifier(table)
+	}
+	return `VACUUM (` + strings.Join(flags, `, `) + `) ` + identifier(table)
+}
+
+// Analyze - updates the planner statistics of a single table
+func (p *DBPool) Analyze(ctx context.Context, table string) error {
+	_, err := p.ExecuteContext(ctx, `ANALYZE `+identifier(table))
+	return err
+}
+
+// Reindex - rebuilds the indexes of a table, concurrently avoids blocking writes but takes longer (postgres 12+)
+func (p *DBPool) Reindex(ctx context.Context, table string, concurrently bool) error {
+	q := `REINDEX TABLE `
+	if concurrently {
+		q += `CONCURRENTLY `
+	}
+	_, err := p.ExecuteContext(ctx, q+identifier(table))
+	return err
+}
+
+// TableStat - activity and dead tuple counts for a table, times are nil if the operation has never run
+// DeadRatio is dead / (live + dead) tuples, a high ratio suggests the table needs vacuuming
+type TableStat struct {
+	Schema           string     `db:"schemaname"`
+	Table            string     `db:"relname"`
+	LiveTuples       int64      `db:"n_live_tup"`
+	DeadTuples       int64      `db:"n_dead_tup"`
+	DeadRatio        float64    `db:"dead_ratio"`
+	ModsSinceAnalyze int64      `db:"n_mod_since_analyze"`
+	LastVacuum       *time.Time `db:"last_vacuum"`
+	LastAutovacuum   *time.Time `db:"last_autovacuum"`
+	LastAnalyze      *time.Time `db:"last_analyze"`
+	LastAutoanalyze  *time.Time `db:"last_autoanalyze"`
+}
+
+// DeadTupleReport - tables with at least minDead dead tuples, most dead tuples first
+// dead tuples are cleared by vacuum, see TableBloatReport for the space they leave behind
+func (p *DBPool) DeadTupleReport(ctx context.Context, minDead int64) ([]TableStat, error) {
+	return SelectAll[TableStat](ctx, p, `SELECT schemaname, relname, n_live_tup, n_dead_tup,
+		COALESCE(n_dead_tup::float8 / NULLIF(n_live_tup + n_dead_tup, 0), 0) AS dead_ratio, n_mod_since_analyze,
+		last_vacuum, last_autovacuum, last_analyze, last_autoanalyze
+		FROM pg_stat_user_tables WHERE n_dead_tup >= $1 ORDER BY n_dead_tup DESC`, minDead)
+}
+
+// TableBloat - estimated space in a table that is not used by live rows, including TOAST data
+// the estimate compares the pages a table occupies with the pages its rows would need, based on the column widths in
+// pg_stats, so it is only as current as the last ANALYZE. Approximate is true when some columns have no statistics
+// or are of type name, which makes the estimate less reliable
+type TableBloat struct {
+	Schema      string  `db:"schemaname"`
+	Table       string  `db:"relname"`
+	Bytes       int64   `db:"bytes"`
+	BloatBytes  int64   `db:"bloat_bytes"`
+	BloatRatio  float64 `db:"bloat_ratio"`
+	Approximate bool    `db:"approximate"`
+}
+
+// tableBloatQuery - the usual estimate from the catalog statistics, assuming 8 byte alignment as on 64 bit servers
+// rows are 24 byte page headers plus tuples of a 23 byte header, null bitmap and average data width, aligned
+const tableBloatQuery = `SELECT schemaname, relname, (bs * pages)::int8 AS bytes,
+	(GREATEST(pages - est_pages, 0) * bs)::int8 AS bloat_bytes,
+	COALESCE(GREATEST(pages - est_pages, 0) / NULLIF(pages, 0), 0)::float8 AS bloat_ratio, approximate
+	FROM (
+		SELECT schemaname, relname, bs, heap_pages + toast_pages AS pages, approximate,
+			ceil(reltuples / ((bs - 24) * fillfactor / (tpl_size * 100))) + ceil(toast_tuples / 4) AS est_pages
+		FROM (
+			SELECT schemaname, relname, bs, heap_pages, toast_pages, reltuples, toast_tuples, fillfactor, approximate,
+				4 + hdr_size + data_size + 16
+				- CASE WHEN hdr_size % 8 = 0 THEN 8 ELSE hdr_size % 8 END
+				- CASE WHEN ceil(data_size)::int % 8 = 0 THEN 8 ELSE ceil(data_size)::int % 8 END AS tpl_size
+			FROM (
+				SELECT ns.nspname AS schemaname, tbl.relname, tbl.reltuples, tbl.relpages AS heap_pages,
+					COALESCE(toast.relpages, 0) AS toast_pages, COALESCE(toast.reltuples, 0) AS toast_tuples,
+					COALESCE(substring(array_to_string(tbl.reloptions, ' ') FROM 'fillfactor=([0-9]+)')::int, 100) AS fillfactor,
+					current_setting('block_size')::numeric AS bs,
+					23 + CASE WHEN max(COALESCE(s.null_frac, 0)) > 0 THEN (7 + count(s.attname)) / 8 ELSE 0 END AS hdr_size,
+					sum((1 - COALESCE(s.null_frac, 0)) * COALESCE(s.avg_width, 0)) AS data_size,
+					bool_or(att.atttypid = 'pg_catalog.name'::regtype) OR count(*) <> count(s.attname) AS approximate
+				FROM pg_attribute att
+				JOIN pg_class tbl ON tbl.oid = att.attrelid
+				JOIN pg_namespace ns ON ns.oid = tbl.relnamespace
+				LEFT JOIN pg_stats s ON s.schemaname = ns.nspname AND s.tablename = tbl.relname
+					AND NOT s.inherited AND s.attname = att.attname
+				LEFT JOIN pg_class toast ON toast.oid = tbl.reltoastrelid
+				WHERE att.attnum > 0 AND NOT att.attisdropped AND tbl.relkind IN ('r', 'm') AND tbl.reltuples >= 0
+					AND ns.nspname NOT IN ('pg_catalog', 'information_schema') AND ns.nspname !~ '^pg_toast'
+				GROUP BY ns.nspname, tbl.relname, tbl.reltuples, tbl.relpages, toast.relpages, toast.reltuples, tbl.reloptions
+			) AS t
+		) AS t
+	) AS t`
+
+// TableBloatReport - tables with an estimated bloat of at least minBytes, most bloated first
+// unlike DeadTupleReport this includes space left behind by dead tuples that vacuum has already removed,
+// which only VACUUM FULL or a table rewrite returns. Tables that have never been analyzed are omitted
+func (p *DBPool) TableBloatReport(ctx context.Context, minBytes int64) ([]TableBloat, error) {
+	return SelectAll[TableBloat](ctx, p, `SELECT * FROM (`+tableBloatQuery+`) AS b
+		WHERE bloat_bytes >= $1 ORDER BY bloat_bytes DESC`, minBytes)
+}
+
+// TableSize - sizes in bytes, TotalBytes includes indexes and TOAST data
+type TableSize struct {
+	Schema     string `db:"schemaname"`
+	Table      string `db:"relname"`
+	TotalBytes int64  `db:"total_bytes"`
+	TableBytes int64  `db:"table_bytes"`
+	IndexBytes int64  `db:"index_bytes"`
+}
+
+// TableSizes - every user table, largest first
+func (p *DBPool) TableSizes(ctx context.Context) ([]TableSize, error) {
+	return SelectAll[TableSize](ctx, p, `SELECT schemaname, relname,
+		pg_total_relation_size(relid) AS total_bytes, pg_table_size(relid) AS table_bytes,
+		pg_indexes_size(relid) AS index_bytes
+		FROM pg_stat_user_tables ORDER BY total_bytes DESC`)
+}
+
+// IndexStat - size and usage of an index. Scans counts index scans since statistics were last reset
+// Unique is true for unique and primary key indexes, which enforce constraints even if never scanned
+type IndexStat struct {
+	Schema string `db:"schemaname"`
+	Table  string `db:"relname"`
+	Index  string `db:"indexrelname"`
+	Bytes  int64  `db:"bytes"`
+	Scans  int64  `db:"idx_scan"`
+	Unique bool   `db:"indisunique"`
+}
+
+const indexStatsQuery = `SELECT s.schemaname, s.relname, s.indexrelname, pg_relation_size(s.indexrelid) AS bytes,
+	s.idx_scan, i.indisunique
+	FROM pg_stat_user_indexes s JOIN pg_index i ON i.indexrelid = s.indexrelid`
+
+// IndexSizes - every user index, largest first
+func (p *DBPool) IndexSizes(ctx context.Context) ([]IndexStat, error) {
+	return SelectAll[IndexStat](ctx, p, indexStatsQuery+` ORDER BY bytes DESC`)
+}
+
+// UnusedIndexes - indexes never scanned since statistics were last reset, excluding unique indexes, largest first
+// statistics are per server, so check replicas too before dropping an index used only by read queries
+func (p *DBPool) UnusedIndexes(ctx context.Context) ([]IndexStat, error) {
+	return SelectAll[IndexStat](ctx, p, indexStatsQuery+` WHERE s.idx_scan = 0 AND NOT i.indisunique ORDER BY bytes DESC`)
+}
+
+// Activity - a server process running a statement
+// Duration is how long the current statement has been running, BlockedBy the processes holding locks it waits for
+type Activity struct {
+	PID           int32         `db:"pid"`
+	User          *string       `db:"usename"`
+	Application   string        `db:"application_name"`
+	ClientAddr    *string       `db:"client_addr"`
+	State         string        `db:"state"`
+	WaitEventType *string       `db:"wait_event_type"`
+	WaitEvent     *string       `db:"wait_event"`
+	Query         string        `db:"query"`
+	QueryStart    time.Time     `db:"query_start"`
+	Duration      time.Duration `db:"duration"`
+	BlockedBy     []int32       `db:"blocked_by"`
+}
+
+const activityQuery = `SELECT pid, usename, application_name, client_addr::text, state, wait_event_type, wait_event,
+	query, query_start, (EXTRACT(EPOCH FROM now() - query_start) * 1e9)::bigint AS duration,
+	pg_blocking_pids(pid) AS blocked_by
+	FROM pg_stat_activity
+	WHERE pid <> pg_backend_pid() AND state <> 'idle' AND query_start IS NOT NULL`
+
+// LongRunningQueries - statements that have been running for at least min, longest first
+func (p *DBPool) LongRunningQueries(ctx context.Context, min time.Duration) ([]Activity, error) {
+	return SelectAll[Activity](ctx, p, activityQuery+` AND now() - query_start >= $1 * interval '1 microsecond'
+		ORDER BY query_start`, min.Microseconds())
+}
+
+// LockWaits - statements waiting for a lock held by another process, longest waiting first
+func (p *DBPool) LockWaits(ctx context.Context) ([]Activity, error) {
+	return SelectAll[Activity](ctx, p, activityQuery+` AND cardinality(pg_blocking_pids(pid)) > 0 ORDER BY query_start`)
+}
+
+// CancelBackend - cancels the statement running in process pid, returns false if there is no such process
+func (p *DBPool) CancelBackend(ctx context.Context, pid int32) (bool, error) {
+	return SelectScalar[bool](ctx, p, `SELECT pg_cancel_backend($1)`, pid)
+}
+
+// TerminateBackend - ends process pid, closing its connection and rolling back its transaction
+// returns false if there is no such process
+func (p *DBPool) TerminateBackend(ctx context.Context, pid int32) (bool, error) {
+	return SelectScalar[bool](ctx, p, `SELECT pg_terminate_backend($1)`, pid)
+}
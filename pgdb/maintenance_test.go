@@ -0,0 +1,78 @@
+package pgdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_vacuumSQL(t *testing.T) {
+	tests := []struct {
+		opts VacuumOptions
+		want string
+	}{
+		{VacuumOptions{}, `VACUUM "public"."users"`},
+		{VacuumOptions{Analyze: true}, `VACUUM (ANALYZE) "public"."users"`},
+		{VacuumOptions{Full: true, Freeze: true, Analyze: true}, `VACUUM (FULL, FREEZE, ANALYZE) "public"."users"`},
+	}
+	for _, tt := range tests {
+		if got := vacuumSQL(`public.users`, tt.opts); got != tt.want {
+			t.Errorf(`vacuumSQL(%+v) = %s, want %s`, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func Test_Maintenance(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	_, _ = p.Execute(`create table if not exists maint_test (id int primary key, name text)`)
+	defer p.Execute(`drop table maint_test`)
+	_, _ = p.Execute(`create index if not exists maint_test_name on maint_test (name)`)
+	_, _ = p.Execute(`insert into maint_test select g, 'x' from generate_series(1, 100) g on conflict do nothing`)
+	_, _ = p.Execute(`delete from maint_test where id > 50`)
+
+	if e := p.Vacuum(ctx, `maint_test`, VacuumOptions{Analyze: true}); e != nil {
+		t.Error(e)
+	}
+	if e := p.Analyze(ctx, `maint_test`); e != nil {
+		t.Error(e)
+	}
+	if e := p.Reindex(ctx, `maint_test`, false); e != nil {
+		t.Error(e)
+	}
+	if _, e := p.DeadTupleReport(ctx, 0); e != nil {
+		t.Error(e)
+	}
+	bloat, e := p.TableBloatReport(ctx, 0)
+	if e != nil {
+		t.Error(e)
+	}
+	for _, b := range bloat {
+		if b.BloatBytes < 0 || b.BloatBytes > b.Bytes || b.BloatRatio < 0 || b.BloatRatio > 1 {
+			t.Error(`implausible bloat estimate`, b)
+		}
+	}
+	sizes, e := p.TableSizes(ctx)
+	if e != nil || len(sizes) == 0 {
+		t.Error(`TableSizes failed`, e)
+	}
+	unused, e := p.UnusedIndexes(ctx)
+	if e != nil {
+		t.Error(e)
+	}
+	for _, u := range unused {
+		if u.Unique {
+			t.Error(`unique indexes should not be reported as unused`, u)
+		}
+	}
+	if _, e = p.LongRunningQueries(ctx, time.Minute); e != nil {
+		t.Error(e)
+	}
+	if _, e = p.LockWaits(ctx); e != nil {
+		t.Error(e)
+	}
+	if ok, e := p.CancelBackend(ctx, 0); ok || e != nil {
+		t.Error(`CancelBackend of a missing process should return false`, e)
+	}
+}
@@ -0,0 +1,421 @@
+package pgdb
+
+/*
+	schema migrations: versioned up / down sql files read from an fs.FS (e.g. embed.FS) or a cloud storage prefix
+	files are named <version>_<description>.up.sql and <version>_<description>.down.sql, e.g. 0001_create_users.up.sql
+	applied versions are recorded in a table (schema_migrations by default) together with a checksum of the up script,
+	and a postgres advisory lock keeps concurrent runners from applying the same migrations.
+	Each migration runs inside a transaction, so statements that cannot, such as CREATE INDEX CONCURRENTLY, may not be used
+*/
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMigrationTable - table recording applied migrations when MigrateOptions.Table is not set
+const DefaultMigrationTable = `schema_migrations`
+
+// Migration - one version, Down is empty if the migration cannot be reverted
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationSource - supplies the complete set of migrations, in any order
+type MigrationSource interface {
+	Migrations() ([]Migration, error)
+}
+
+// MigrationSourceFunc - adapts a function to the MigrationSource interface
+type MigrationSourceFunc func() ([]Migration, error)
+
+func (f MigrationSourceFunc) Migrations() ([]Migration, error) {
+	return f()
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// MigrationsFromFS - reads the migration files in dir, other files are ignored
+func MigrationsFromFS(fsys fs.FS, dir string) MigrationSource {
+	return MigrationSourceFunc(func() ([]Migration, error) {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		files := make(map[string][]byte)
+		for _, e := range entries {
+			if e.IsDir() || !migrationFile.MatchString(e.Name()) {
+				continue
+			}
+			if files[e.Name()], err = fs.ReadFile(fsys, path.Join(dir, e.Name())); err != nil {
+				return nil, err
+			}
+		}
+		return parseMigrations(files)
+	})
+}
+
+// ObjectStore - lists and reads objects by name, satisfied by *storage.CStore
+type ObjectStore interface {
+	GetFiles(prefix string) ([]string, error)
+	ReadFile(name string) ([]byte, error)
+}
+
+// MigrationsFromCStore - reads the migration files directly under prefix, e.g. "migrations/", from a
+// *storage.CStore or any other ObjectStore
+func MigrationsFromCStore(cs ObjectStore, prefix string) MigrationSource {
+	return MigrationSourceFunc(func() ([]Migration, error) {
+		names, err := cs.GetFiles(prefix)
+		if err != nil {
+			return nil, err
+		}
+		files := make(map[string][]byte)
+		for _, n := range names {
+			base := strings.TrimPrefix(n, prefix)
+			if strings.Contains(base, `/`) || !migrationFile.MatchString(base) {
+				continue
+			}
+			if files[base], err = cs.ReadFile(n); err != nil {
+				return nil, err
+			}
+		}
+		return parseMigrations(files)
+	})
+}
+
+// parseMigrations - pairs up / down files by version, sorted by version
+func parseMigrations(files map[string][]byte) ([]Migration, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	byVersion := make(map[int64]*Migration)
+	// file each script came from, to detect versions written differently, e.g. 1_init.up.sql and 0001_init.up.sql
+	sources := make(map[string]string)
+	for _, name := range names {
+		content := files[name]
+		m := migrationFile.FindStringSubmatch(name)
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid migration version %s: %v`, name, err)
+		}
+		mg, ok := byVersion[v]
+		if !ok {
+			mg = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mg
+		} else if mg.Name != m[2] {
+			return nil, fmt.Errorf(`duplicate migration version %d: %s and %s`, v, mg.Name, m[2])
+		}
+		key := strconv.FormatInt(v, 10) + `.` + m[3]
+		if prev, ok := sources[key]; ok {
+			return nil, fmt.Errorf(`duplicate migration version %d: %s and %s`, v, prev, name)
+		}
+		sources[key] = name
+		if m[3] == `up` {
+			mg.Up = string(content)
+		} else {
+			mg.Down = string(content)
+		}
+	}
+	result := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if len(mg.Up) == 0 {
+			return nil, fmt.Errorf(`migration %d_%s has no up script`, mg.Version, mg.Name)
+		}
+		mg.Checksum = checksumSQL(mg.Up)
+		result = append(result, *mg)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+func checksumSQL(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrateOptions - options for NewMigrator
+// Table - defaults to DefaultMigrationTable, may be schema qualified
+// IgnoreDrift - apply migrations even if the checksum of an applied migration no longer matches its source
+// DryRun - report the steps that would be run without changing the database
+type MigrateOptions struct {
+	Table       string
+	IgnoreDrift bool
+	DryRun      bool
+}
+
+// DriftError - an applied migration has been edited since it was applied, or is no longer in the source
+type DriftError struct {
+	Version  int64
+	Name     string
+	Applied  string
+	Expected string
+}
+
+func (e *DriftError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf(`applied migration %d_%s is missing from the source`, e.Version, e.Name)
+	}
+	return fmt.Sprintf(`migration %d_%s has changed since it was applied (checksum %s, was %s)`,
+		e.Version, e.Name, e.Expected, e.Applied)
+}
+
+// ErrIrreversible - migrating down requires a migration with no down script
+var ErrIrreversible = errors.New(`migration has no down script`)
+
+// MigrationStep - a migration run (or, for a dry run, to be run) in the given direction
+type MigrationStep struct {
+	Version  int64
+	Name     string
+	Down     bool
+	SQL      string
+	Duration time.Duration
+}
+
+// MigrationStatus - a migration from the source or the migrations table
+// AppliedAt is zero if it has not been applied, Drifted is true if the checksums differ or the source is missing
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+	Drifted   bool
+}
+
+// Migrator - applies migrations from a source to the database
+type Migrator struct {
+	pool *DBPool
+	src  MigrationSource
+	opts MigrateOptions
+}
+
+// NewMigrator -
+func NewMigrator(p *DBPool, src MigrationSource, opts MigrateOptions) *Migrator {
+	this := new(Migrator)
+	this.pool = p
+	this.src = src
+	this.opts = opts
+	if len(this.opts.Table) == 0 {
+		this.opts.Table = DefaultMigrationTable
+	}
+	return this
+}
+
+// Up - applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStep, error) {
+	return m.run(ctx, -1)
+}
+
+// To - migrates up or down to version, version 0 reverts every migration
+func (m *Migrator) To(ctx context.Context, version int64) ([]MigrationStep, error) {
+	if version < 0 {
+		return nil, errors.New(`invalid migration version`)
+	}
+	return m.run(ctx, version)
+}
+
+// Version - the highest applied version, 0 if none have been applied
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.applied(ctx, m.pool.DBCon)
+	if err != nil {
+		return 0, err
+	}
+	var result int64
+	for v := range applied {
+		if v > result {
+			result = v
+		}
+	}
+	return result, nil
+}
+
+// Status - every migration in the source or the migrations table, by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	all, err := m.src.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, m.pool.DBCon)
+	if err != nil {
+		return nil, err
+	}
+	var result []MigrationStatus
+	for _, mg := range all {
+		s := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if a, ok := applied[mg.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			s.Drifted = a.Checksum != mg.Checksum
+			delete(applied, mg.Version)
+		}
+		result = append(result, s)
+	}
+	for _, a := range applied {
+		result = append(result, MigrationStatus{Version: a.Version, Name: a.Name, AppliedAt: a.AppliedAt, Drifted: true})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// run - target < 0 means the latest version
+func (m *Migrator) run(ctx context.Context, target int64) ([]MigrationStep, error) {
+	all, err := m.src.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if m.opts.DryRun {
+		applied, err := m.applied(ctx, m.pool.DBCon)
+		if err != nil {
+			return nil, err
+		}
+		return m.plan(all, applied, target)
+	}
+
+	// the connection holding the session level lock also runs the migrations
+	key := StringLockKey(m.lockName())
+	conn, err := m.pool.lockConn(ctx, key, false)
+	if err != nil {
+		return nil, err
+	}
+	defer m.pool.unlockConn(conn, key)
+	db := observed{db: conn, obs: m.pool.obs}
+
+	if _, err = db.ExecuteContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		checksum text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now())`, m.table())); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := m.plan(all, applied, target)
+	if err != nil {
+		return nil, err
+	}
+	for i := range steps {
+		start := time.Now()
+		if err = m.apply(ctx, conn, steps[i], all); err != nil {
+			return steps[:i], fmt.Errorf(`migration %d_%s failed: %w`, steps[i].Version, steps[i].Name, err)
+		}
+		steps[i].Duration = time.Since(start)
+	}
+	return steps, nil
+}
+
+// apply - runs the step and records it in the migrations table, in a single transaction
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, s MigrationStep, all []Migration) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	return finish(ctx, tx, m.pool.obs, func(tx *Tx) error {
+		// no arguments, so the script is sent with the simple protocol and may contain several statements
+		if _, err := tx.ExecuteContext(ctx, s.SQL); err != nil {
+			return err
+		}
+		if s.Down {
+			_, err := tx.ExecuteContext(ctx, `DELETE FROM `+m.table()+` WHERE version = $1`, s.Version)
+			return err
+		}
+		var checksum string
+		for _, mg := range all {
+			if mg.Version == s.Version {
+				checksum = mg.Checksum
+			}
+		}
+		_, err := tx.ExecuteContext(ctx, `INSERT INTO `+m.table()+` (version, name, checksum) VALUES ($1, $2, $3)`,
+			s.Version, s.Name, checksum)
+		return err
+	})
+}
+
+// plan - the steps required to move from the applied migrations to target, checking for drift first
+func (m *Migrator) plan(all []Migration, applied map[int64]appliedMigration, target int64) ([]MigrationStep, error) {
+	source := make(map[int64]Migration, len(all))
+	for _, mg := range all {
+		source[mg.Version] = mg
+	}
+	if !m.opts.IgnoreDrift {
+		for _, a := range applied {
+			mg, ok := source[a.Version]
+			if !ok || mg.Checksum != a.Checksum {
+				return nil, &DriftError{Version: a.Version, Name: a.Name, Applied: a.Checksum, Expected: mg.Checksum}
+			}
+		}
+	}
+	if target < 0 && len(all) > 0 {
+		target = all[len(all)-1].Version
+	}
+	var steps []MigrationStep
+	for _, mg := range all {
+		if _, ok := applied[mg.Version]; !ok && mg.Version <= target {
+			steps = append(steps, MigrationStep{Version: mg.Version, Name: mg.Name, SQL: mg.Up})
+		}
+	}
+	var down []int64
+	for v := range applied {
+		if v > target {
+			down = append(down, v)
+		}
+	}
+	sort.Slice(down, func(i, j int) bool { return down[i] > down[j] })
+	for _, v := range down {
+		mg, ok := source[v]
+		if !ok || len(mg.Down) == 0 {
+			return nil, fmt.Errorf(`unable to revert migration %d_%s: %w`, v, applied[v].Name, ErrIrreversible)
+		}
+		steps = append(steps, MigrationStep{Version: v, Name: mg.Name, Down: true, SQL: mg.Down})
+	}
+	return steps, nil
+}
+
+// applied - the contents of the migrations table, empty if the table does not exist yet
+func (m *Migrator) applied(ctx context.Context, db pgxQuerier) (map[int64]appliedMigration, error) {
+	result := make(map[int64]appliedMigration)
+	q := observed{db: db, obs: m.pool.obs}
+	exists, err := SelectScalar[bool](ctx, q, `SELECT to_regclass($1) IS NOT NULL`, m.table())
+	if err != nil || !exists {
+		return result, err
+	}
+	list, err := SelectAll[appliedMigration](ctx, q, `SELECT version, name, checksum, applied_at FROM `+m.table())
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		result[a.Version] = a
+	}
+	return result, nil
+}
+
+func (m *Migrator) table() string {
+	return identifier(m.opts.Table)
+}
+
+// lockName - runners using different migration tables do not block each other
+func (m *Migrator) lockName() string {
+	return `pgdb.migrate:` + m.opts.Table
+}
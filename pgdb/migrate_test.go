@@ -0,0 +1,135 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		`db/0001_create.up.sql`:   {Data: []byte(`create table migrate_test (id int)`)},
+		`db/0001_create.down.sql`: {Data: []byte(`drop table migrate_test`)},
+		`db/0002_add.up.sql`:      {Data: []byte(`alter table migrate_test add name text`)},
+		`db/0002_add.down.sql`:    {Data: []byte(`alter table migrate_test drop name`)},
+		`db/0003_seed.up.sql`:     {Data: []byte(`insert into migrate_test values (1, 'a')`)},
+		`db/readme.md`:            {Data: []byte(`ignored`)},
+	}
+}
+
+func Test_MigrationsFromFS(t *testing.T) {
+	all, e := MigrationsFromFS(testMigrations(), `db`).Migrations()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(all) != 3 || all[0].Version != 1 || all[1].Name != `add` || all[2].Down != `` {
+		t.Errorf(`unexpected migrations %+v`, all)
+	}
+	if all[0].Checksum != checksumSQL(`create table migrate_test (id int)`) {
+		t.Error(`unexpected checksum`)
+	}
+
+	fsys := testMigrations()
+	fsys[`db/0002_other.up.sql`] = &fstest.MapFile{Data: []byte(`select 1`)}
+	if _, e = MigrationsFromFS(fsys, `db`).Migrations(); e == nil {
+		t.Error(`Expected error for duplicate version`)
+	}
+	fsys = fstest.MapFS{`db/0004_x.down.sql`: {Data: []byte(`select 1`)}}
+	if _, e = MigrationsFromFS(fsys, `db`).Migrations(); e == nil {
+		t.Error(`Expected error for missing up script`)
+	}
+	fsys = testMigrations()
+	fsys[`db/1_create.up.sql`] = &fstest.MapFile{Data: []byte(`select 1`)}
+	if _, e = MigrationsFromFS(fsys, `db`).Migrations(); e == nil {
+		t.Error(`Expected error for the same version written differently`)
+	}
+}
+
+type mapStore fstest.MapFS
+
+func (m mapStore) GetFiles(prefix string) ([]string, error) {
+	var result []string
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			result = append(result, k)
+		}
+	}
+	return result, nil
+}
+
+func (m mapStore) ReadFile(name string) ([]byte, error) {
+	return m[name].Data, nil
+}
+
+func Test_MigrationsFromCStore(t *testing.T) {
+	store := mapStore(testMigrations())
+	store[`db/nested/0009_skip.up.sql`] = &fstest.MapFile{Data: []byte(`select 1`)}
+	all, e := MigrationsFromCStore(store, `db/`).Migrations()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(all) != 3 || all[2].Version != 3 {
+		t.Errorf(`unexpected migrations %+v`, all)
+	}
+}
+
+func Test_plan(t *testing.T) {
+	all, _ := MigrationsFromFS(testMigrations(), `db`).Migrations()
+	m := NewMigrator(nil, nil, MigrateOptions{})
+	applied := map[int64]appliedMigration{1: {Version: 1, Name: `create`, Checksum: all[0].Checksum}}
+
+	steps, e := m.plan(all, applied, -1)
+	if e != nil || len(steps) != 2 || steps[0].Version != 2 || steps[1].Down {
+		t.Errorf(`unexpected up plan %+v %v`, steps, e)
+	}
+	applied[2] = appliedMigration{Version: 2, Name: `add`, Checksum: all[1].Checksum}
+	steps, e = m.plan(all, applied, 0)
+	if e != nil || len(steps) != 2 || steps[0].Version != 2 || !steps[0].Down || steps[1].SQL != `drop table migrate_test` {
+		t.Errorf(`unexpected down plan %+v %v`, steps, e)
+	}
+	applied[3] = appliedMigration{Version: 3, Name: `seed`, Checksum: all[2].Checksum}
+	if _, e = m.plan(all, applied, 1); !errors.Is(e, ErrIrreversible) {
+		t.Errorf(`Expected ErrIrreversible, got %v`, e)
+	}
+
+	applied[2] = appliedMigration{Version: 2, Name: `add`, Checksum: `edited`}
+	var de *DriftError
+	if _, e = m.plan(all, applied, -1); !errors.As(e, &de) || de.Version != 2 {
+		t.Errorf(`Expected drift error, got %v`, e)
+	}
+	m.opts.IgnoreDrift = true
+	if steps, e = m.plan(all, applied, -1); e != nil || len(steps) != 0 {
+		t.Errorf(`drift should be ignored, got %+v %v`, steps, e)
+	}
+}
+
+func Test_Migrator(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	m := NewMigrator(p, MigrationsFromFS(testMigrations(), `db`), MigrateOptions{Table: `migrate_test_versions`})
+	defer p.Execute(`drop table if exists migrate_test, migrate_test_versions`)
+
+	dry := NewMigrator(p, MigrationsFromFS(testMigrations(), `db`), MigrateOptions{Table: `migrate_test_versions`, DryRun: true})
+	if steps, e := dry.Up(ctx); e != nil || len(steps) != 3 {
+		t.Error(`dry run failed`, steps, e)
+	}
+	if v, _ := m.Version(ctx); v != 0 {
+		t.Error(`dry run should not apply migrations`)
+	}
+	if steps, e := m.Up(ctx); e != nil || len(steps) != 3 {
+		t.Error(`Up failed`, steps, e)
+	}
+	if steps, e := m.To(ctx, 1); e == nil {
+		t.Error(`Expected error reverting migration without down script`, steps)
+	}
+	if steps, e := m.Up(ctx); e != nil || len(steps) != 0 {
+		t.Error(`Up should have nothing to do`, steps, e)
+	}
+	st, e := m.Status(ctx)
+	if e != nil || len(st) != 3 || st[2].AppliedAt.IsZero() || st[2].Drifted {
+		t.Error(`unexpected status`, st, e)
+	}
+}
@@ -0,0 +1,165 @@
+package pgdb
+
+/*
+	query logging, slow query detection, tracing and statistics for DBPool
+	pgx's own connection logging is configured with WithLogger / WithLogrus, the remaining hooks
+	observe every statement run through DBPool and Tx
+*/
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/log/logrusadapter"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// QueryEvent - describes a completed statement, Args is nil when arguments are redacted
+type QueryEvent struct {
+	SQL      string
+	Args     []interface{}
+	Start    time.Time
+	Duration time.Duration
+	Rows     int64
+	Err      error
+}
+
+// QueryTracer - OpenTelemetry style hook, StartQuery may return a context carrying a span which is passed to EndQuery
+type QueryTracer interface {
+	StartQuery(ctx context.Context, sql string) context.Context
+	EndQuery(ctx context.Context, ev QueryEvent)
+}
+
+// QueryStatsSink - receives an event for every completed statement, must be safe for concurrent use
+type QueryStatsSink interface {
+	RecordQuery(ev QueryEvent)
+}
+
+// QueryStatsFunc - adapts a function to the QueryStatsSink interface
+type QueryStatsFunc func(ev QueryEvent)
+
+func (f QueryStatsFunc) RecordQuery(ev QueryEvent) {
+	f(ev)
+}
+
+// WithLogger - pgx logs through l at the given level, e.g. pgx.LogLevelWarn
+func WithLogger(l pgx.Logger, level pgx.LogLevel) PoolOption {
+	return func(c *poolConfig) {
+		c.logger = l
+		c.logLevel = level
+	}
+}
+
+// WithLogrus - pgx logs through the logrus logger at the given level
+func WithLogrus(l *logrus.Logger, level pgx.LogLevel) PoolOption {
+	return WithLogger(logrusadapter.NewLogger(l), level)
+}
+
+// WithSlowQueryLog - statements taking longer than threshold are logged at warn level, to the logger given by
+// WithLogger / WithLogrus or to the logrus standard logger if there is none
+func WithSlowQueryLog(threshold time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.slowQuery = threshold
+	}
+}
+
+// WithRedactedArgs - statement arguments are removed from log output, slow query logs and QueryEvents
+func WithRedactedArgs() PoolOption {
+	return func(c *poolConfig) {
+		c.redactArgs = true
+	}
+}
+
+// WithTracer - tracer is called around every statement
+func WithTracer(t QueryTracer) PoolOption {
+	return func(c *poolConfig) {
+		c.tracer = t
+	}
+}
+
+// WithStatsSink - sink receives an event for every completed statement
+func WithStatsSink(s QueryStatsSink) PoolOption {
+	return func(c *poolConfig) {
+		c.statsSink = s
+	}
+}
+
+// pgxLogger - the logger to give pgx, if any
+func (c *poolConfig) pgxLogger() pgx.Logger {
+	if c.logger == nil {
+		return nil
+	}
+	if c.redactArgs {
+		return redactingLogger{c.logger}
+	}
+	return c.logger
+}
+
+// newObserver - nil if no statement level hooks are configured
+func (c *poolConfig) newObserver() *observer {
+	if c.slowQuery <= 0 && c.tracer == nil && c.statsSink == nil {
+		return nil
+	}
+	result := &observer{slowQuery: c.slowQuery, redact: c.redactArgs, tracer: c.tracer, sink: c.statsSink, logger: c.logger}
+	if result.logger == nil {
+		result.logger = logrusadapter.NewLogger(logrus.StandardLogger())
+	}
+	return result
+}
+
+type observer struct {
+	logger    pgx.Logger
+	slowQuery time.Duration
+	redact    bool
+	tracer    QueryTracer
+	sink      QueryStatsSink
+}
+
+// start - called before a statement is run, the returned function must be called once it completes
+// safe to call on a nil observer
+func (o *observer) start(ctx context.Context, sql string, args []interface{}) (context.Context, func(rows int64, err error)) {
+	if o == nil {
+		return ctx, func(int64, error) {}
+	}
+	if o.tracer != nil {
+		ctx = o.tracer.StartQuery(ctx, sql)
+	}
+	if o.redact {
+		args = nil
+	}
+	start := time.Now()
+	return ctx, func(rows int64, err error) {
+		ev := QueryEvent{SQL: sql, Args: args, Start: start, Duration: time.Since(start), Rows: rows, Err: err}
+		if o.slowQuery > 0 && ev.Duration >= o.slowQuery {
+			data := map[string]interface{}{`sql`: sql, `time`: ev.Duration, `rowCount`: rows}
+			if !o.redact {
+				data[`args`] = args
+			}
+			o.logger.Log(ctx, pgx.LogLevelWarn, `slow query`, data)
+		}
+		if o.tracer != nil {
+			o.tracer.EndQuery(ctx, ev)
+		}
+		if o.sink != nil {
+			o.sink.RecordQuery(ev)
+		}
+	}
+}
+
+// redactingLogger - removes statement arguments from pgx log output
+type redactingLogger struct {
+	pgx.Logger
+}
+
+func (l redactingLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	if _, ok := data[`args`]; ok {
+		clean := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			if k != `args` {
+				clean[k] = v
+			}
+		}
+		data = clean
+	}
+	l.Logger.Log(ctx, level, msg, data)
+}
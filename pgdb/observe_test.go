@@ -0,0 +1,99 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	msgs []string
+	data []map[string]interface{}
+}
+
+func (l *testLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	l.msgs = append(l.msgs, msg)
+	l.data = append(l.data, data)
+}
+
+type testTracer struct {
+	started, ended int
+}
+
+type spanKey struct{}
+
+func (t *testTracer) StartQuery(ctx context.Context, sql string) context.Context {
+	t.started++
+	return context.WithValue(ctx, spanKey{}, sql)
+}
+
+func (t *testTracer) EndQuery(ctx context.Context, ev QueryEvent) {
+	if ctx.Value(spanKey{}) == ev.SQL {
+		t.ended++
+	}
+}
+
+// fakeExec - pgxQuerier returning canned results
+type fakeExec struct {
+	rows *fakeRows
+	err  error
+}
+
+func (f fakeExec) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return f.rows, f.err
+}
+
+func (f fakeExec) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag(`UPDATE 3`), f.err
+}
+
+func Test_observer(t *testing.T) {
+	l := new(testLogger)
+	tr := new(testTracer)
+	var events []QueryEvent
+	pc := newPoolConfig([]PoolOption{WithLogger(l, pgx.LogLevelWarn), WithSlowQueryLog(time.Nanosecond),
+		WithRedactedArgs(), WithTracer(tr), WithStatsSink(QueryStatsFunc(func(ev QueryEvent) {
+			events = append(events, ev)
+		}))})
+	obs := pc.newObserver()
+
+	n, err := execute(context.Background(), fakeExec{}, obs, `update t set a = $1`, []interface{}{`secret`})
+	if n != 3 || err != nil {
+		t.Error(`execute failed`, n, err)
+	}
+	cancelled := false
+	rows, _ := query(context.Background(), fakeExec{rows: &fakeRows{cols: []string{`a`}, data: [][]interface{}{{1}}}},
+		obs, func() { cancelled = true }, `select a from t`, nil)
+	for rows.Next() {
+	}
+	rows.Close()
+	_, err = execute(context.Background(), fakeExec{err: errors.New(`failed`)}, obs, `delete from t`, nil)
+
+	if len(events) != 3 || events[0].Rows != 3 || events[0].Args != nil || events[2].Err == nil {
+		t.Errorf(`unexpected events %+v`, events)
+	}
+	if !cancelled {
+		t.Error(`statement timeout should be released when rows are exhausted`)
+	}
+	if tr.started != 3 || tr.ended != 3 {
+		t.Errorf(`tracer called %d / %d times`, tr.started, tr.ended)
+	}
+	if len(l.msgs) != 3 || l.msgs[0] != `slow query` || l.data[0][`args`] != nil {
+		t.Errorf(`unexpected slow query log %v %v`, l.msgs, l.data)
+	}
+}
+
+func Test_redactingLogger(t *testing.T) {
+	l := new(testLogger)
+	pc := newPoolConfig([]PoolOption{WithLogger(l, pgx.LogLevelInfo), WithRedactedArgs()})
+	pc.pgxLogger().Log(context.Background(), pgx.LogLevelInfo, `Query`, map[string]interface{}{`sql`: `x`, `args`: []interface{}{1}})
+	if _, ok := l.data[0][`args`]; ok || l.data[0][`sql`] != `x` {
+		t.Errorf(`args should have been removed %v`, l.data[0])
+	}
+	if newPoolConfig(nil).newObserver() != nil {
+		t.Error(`no observer expected without hooks`)
+	}
+}
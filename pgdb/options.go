@@ -0,0 +1,204 @@
+package pgdb
+
+/*
+	pool configuration, passed as functional options to NewDBPool / NewExternalDBPool
+*/
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strconv"
+	"time"
+)
+
+type poolConfig struct {
+	maxConns          int32
+	minConns          int32
+	maxConnLifetime   time.Duration
+	maxConnIdleTime   time.Duration
+	healthCheckPeriod time.Duration
+	statementTimeout  time.Duration
+	afterConnect      []func(context.Context, *pgx.Conn) error
+	beforeAcquire     []func(context.Context, *pgx.Conn) bool
+	logger            pgx.Logger
+	logLevel          pgx.LogLevel
+	slowQuery         time.Duration
+	redactArgs        bool
+	tracer            QueryTracer
+	statsSink         QueryStatsSink
+	cacheMode         StatementCacheMode
+	cacheSize         int
+	cacheSet          bool
+	statements        map[string]string
+}
+
+// PoolOption - configures a DBPool at construction
+type PoolOption func(*poolConfig)
+
+// WithMaxConns - maximum size of the pool, defaults to 20. Must be at least 1, and no less than WithMinConns
+func WithMaxConns(n int32) PoolOption {
+	return func(c *poolConfig) {
+		c.maxConns = n
+	}
+}
+
+// WithMinConns - number of connections the pool keeps open even when idle
+func WithMinConns(n int32) PoolOption {
+	return func(c *poolConfig) {
+		c.minConns = n
+	}
+}
+
+// WithMaxConnLifetime - connections older than this are closed and replaced
+func WithMaxConnLifetime(d time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.maxConnLifetime = d
+	}
+}
+
+// WithMaxConnIdleTime - connections idle for longer than this are closed
+func WithMaxConnIdleTime(d time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.maxConnIdleTime = d
+	}
+}
+
+// WithHealthCheckPeriod - how often idle connections are checked
+func WithHealthCheckPeriod(d time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.healthCheckPeriod = d
+	}
+}
+
+// WithStatementTimeout - see DBPool.SetStatementTimeout
+func WithStatementTimeout(d time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.statementTimeout = d
+	}
+}
+
+// WithAfterConnect - called for every new connection, before it is added to the pool. May be given more than once
+func WithAfterConnect(fn func(context.Context, *pgx.Conn) error) PoolOption {
+	return func(c *poolConfig) {
+		c.afterConnect = append(c.afterConnect, fn)
+	}
+}
+
+// WithBeforeAcquire - called before a connection is handed out, returning false discards the connection. May be given more than once
+func WithBeforeAcquire(fn func(context.Context, *pgx.Conn) bool) PoolOption {
+	return func(c *poolConfig) {
+		c.beforeAcquire = append(c.beforeAcquire, fn)
+	}
+}
+
+func newPoolConfig(opts []PoolOption) *poolConfig {
+	this := new(poolConfig)
+	this.maxConns = maxConnections
+	for _, o := range opts {
+		o(this)
+	}
+	return this
+}
+
+// apply - copy the settings to the pgxpool configuration, zero values leave the pgxpool defaults in place
+func (c *poolConfig) apply(cfg *pgxpool.Config) error {
+	if c.maxConns < 1 {
+		return fmt.Errorf(`max connections must be at least 1, got %d`, c.maxConns)
+	}
+	if c.minConns > c.maxConns {
+		return fmt.Errorf(`min connections (%d) exceeds max connections (%d)`, c.minConns, c.maxConns)
+	}
+	cfg.MaxConns = c.maxConns
+	if c.minConns > 0 {
+		cfg.MinConns = c.minConns
+	}
+	if c.maxConnLifetime > 0 {
+		cfg.MaxConnLifetime = c.maxConnLifetime
+	}
+	if c.maxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = c.maxConnIdleTime
+	}
+	if c.healthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = c.healthCheckPeriod
+	}
+	if l := c.pgxLogger(); l != nil {
+		cfg.ConnConfig.Logger = l
+		cfg.ConnConfig.LogLevel = c.logLevel
+	}
+	c.applyStatements(cfg.ConnConfig)
+	if len(c.afterConnect) > 0 {
+		hooks := c.afterConnect
+		cfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			for _, h := range hooks {
+				if err := h(ctx, conn); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if len(c.beforeAcquire) > 0 {
+		hooks := c.beforeAcquire
+		cfg.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+			for _, h := range hooks {
+				if !h(ctx, conn) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return nil
+}
+
+// SettingsSource - named settings, satisfied by *secrets.Secrets without pgdb depending on the secrets package
+type SettingsSource interface {
+	KeyExists(key string) bool
+	GetString(key string) string
+}
+
+// PoolOptionsFromSecrets - reads pool settings from secrets records named prefix + one of
+// MAX_CONNS, MIN_CONNS, MAX_CONN_LIFETIME, MAX_CONN_IDLE_TIME, HEALTH_CHECK_PERIOD, STATEMENT_TIMEOUT
+// durations use time.ParseDuration format (e.g. "30m"), records that are not present are ignored
+func PoolOptionsFromSecrets(s SettingsSource, prefix string) ([]PoolOption, error) {
+	var result []PoolOption
+	counts := []struct {
+		key string
+		opt func(int32) PoolOption
+	}{
+		{`MAX_CONNS`, WithMaxConns},
+		{`MIN_CONNS`, WithMinConns},
+	}
+	for _, c := range counts {
+		if !s.KeyExists(prefix + c.key) {
+			continue
+		}
+		v, err := strconv.ParseInt(s.GetString(prefix+c.key), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid %s%s: %v`, prefix, c.key, err)
+		}
+		result = append(result, c.opt(int32(v)))
+	}
+	durations := []struct {
+		key string
+		opt func(time.Duration) PoolOption
+	}{
+		{`MAX_CONN_LIFETIME`, WithMaxConnLifetime},
+		{`MAX_CONN_IDLE_TIME`, WithMaxConnIdleTime},
+		{`HEALTH_CHECK_PERIOD`, WithHealthCheckPeriod},
+		{`STATEMENT_TIMEOUT`, WithStatementTimeout},
+	}
+	for _, d := range durations {
+		if !s.KeyExists(prefix + d.key) {
+			continue
+		}
+		v, err := time.ParseDuration(s.GetString(prefix + d.key))
+		if err != nil {
+			return nil, fmt.Errorf(`invalid %s%s: %v`, prefix, d.key, err)
+		}
+		result = append(result, d.opt(v))
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package pgdb
+
+import (
+	"context"
+	"github.com/cambefus/gcp_go_utils/secrets"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"testing"
+	"time"
+)
+
+func Test_poolConfig(t *testing.T) {
+	cfg, _ := pgxpool.ParseConfig(`host=localhost dbname=test`)
+	calls := 0
+	hook := func(ctx context.Context, c *pgx.Conn) error {
+		calls++
+		return nil
+	}
+	pc := newPoolConfig([]PoolOption{WithMinConns(2), WithMaxConnIdleTime(time.Minute), WithAfterConnect(hook), WithAfterConnect(hook)})
+	if e := pc.apply(cfg); e != nil {
+		t.Fatal(e)
+	}
+	if cfg.MaxConns != maxConnections || cfg.MinConns != 2 || cfg.MaxConnIdleTime != time.Minute {
+		t.Errorf(`unexpected pool configuration %+v`, cfg)
+	}
+	_ = cfg.AfterConnect(context.Background(), nil)
+	if calls != 2 {
+		t.Errorf(`Expected both AfterConnect hooks to run, got %d`, calls)
+	}
+	if e := newPoolConfig([]PoolOption{WithMaxConns(0)}).apply(cfg); e == nil {
+		t.Error(`Expected error for zero max connections`)
+	}
+	if e := newPoolConfig([]PoolOption{WithMaxConns(2), WithMinConns(3)}).apply(cfg); e == nil {
+		t.Error(`Expected error for min connections above max`)
+	}
+	if p, e := NewDBPool(`host=localhost dbname=test`, WithMaxConns(0)); p != nil || e == nil {
+		t.Error(`Expected a nil pool and an error for an invalid option`)
+	}
+}
+
+func Test_PoolOptionsFromSecrets(t *testing.T) {
+	s, e := secrets.Parse([]byte(`{"ConfigName": "testing", "Records": [
+		{"Key": "DB_MAX_CONNS", "Value": "5"},
+		{"Key": "DB_STATEMENT_TIMEOUT", "Value": "30s"}]}`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	opts, e := PoolOptionsFromSecrets(s, `DB_`)
+	if e != nil || len(opts) != 2 {
+		t.Fatal(`Expected 2 options`, e)
+	}
+	pc := newPoolConfig(opts)
+	if pc.maxConns != 5 || pc.statementTimeout != 30*time.Second {
+		t.Errorf(`unexpected pool configuration %+v`, pc)
+	}
+	s.Records[1].Value = `thirty seconds`
+	if _, e = PoolOptionsFromSecrets(s, `DB_`); e == nil {
+		t.Error(`Expected error for invalid duration`)
+	}
+	s.Records[1].Value = `30s`
+	s.Records[0].Value = `five`
+	if _, e = PoolOptionsFromSecrets(s, `DB_`); e == nil {
+		t.Error(`Expected error for invalid connection count`)
+	}
+}
@@ -12,46 +12,80 @@ import (
 	"fmt"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v4"
 )
 
 type DBPool struct {
 	DBCon     *pgxpool.Pool
 	connected bool
+	// applied to statements whose context has no deadline, zero means no timeout
+	statementTimeout time.Duration
+	obs              *observer
+	// shared LISTEN connection, created by the first call to Listen
+	lsn        *listener
+	listenOnce sync.Once
+	// registered with WithPreparedStatement, name to sql
+	statements     map[string]string
+	simpleProtocol bool
 }
 
+// CTxt - context used by the methods that do not accept one
 var CTxt = context.Background()
 
 const maxConnections = 20
 
 // NewExternalDBPool - requires TLS certificates to make the connection to the database
 // expects a postgresql connection string the in the form of  "user=xxx password=xxxx host=xx.xx.xx.xx port=xxxx dbname=xxxx sslmode=?????"
-func NewExternalDBPool(cparms, tls_key, tls_cert string) (*DBPool, error) {
-	cert, err := tls.LoadX509KeyPair(tls_cert, tls_key)
+// Deprecated: the server certificate is not verified, use NewExternalDBPoolTLS
+func NewExternalDBPool(cparms, tls_key, tls_cert string, opts ...PoolOption) (*DBPool, error) {
+	o, err := TLSOptionsFromFiles(TLSInsecure, ``, tls_cert, tls_key)
 	if err != nil {
 		return nil, err
 	}
-	tlsc := &tls.Config{Certificates: []tls.Certificate{cert}}
-	tlsc.InsecureSkipVerify = true
-	return getConnection(cparms, tlsc)
+	return NewExternalDBPoolTLS(cparms, o, opts...)
 }
 
 // NewDBPool -
 // expects a postgresql connection string the in the form of  "user=xxx password=xxxx host=xx.xx.xx.xx port=xxxx dbname=xxxx sslmode=?????"
-func NewDBPool(cparms string) (*DBPool, error) {
-	return getConnection(cparms, nil)
+func NewDBPool(cparms string, opts ...PoolOption) (*DBPool, error) {
+	return getConnection(cparms, nil, opts)
 }
 
-func getConnection(cparms string, tls *tls.Config) (*DBPool, error) {
-	this := new(DBPool)
+func getConnection(cparms string, tls *tls.Config, opts []PoolOption) (*DBPool, error) {
 	cfg, e1 := pgxpool.ParseConfig(cparms)
 	if e1 != nil {
 		return nil, errors.New(fmt.Sprintf(`unable to parse connection parameters: %v`, e1))
 	}
+	if tls != nil {
+		if len(tls.ServerName) == 0 {
+			tls = tls.Clone()
+			tls.ServerName = cfg.ConnConfig.Host
+		}
+		// fallbacks (e.g. from sslmode=prefer) must not downgrade to an unverified or plain connection
+		for _, fb := range cfg.ConnConfig.Fallbacks {
+			fb.TLSConfig = tls
+		}
+	}
 	cfg.ConnConfig.TLSConfig = tls
-	cfg.MaxConns = maxConnections
+	return connectConfig(cfg, opts)
+}
+
+// connectConfig - apply the pool options and open the pool
+func connectConfig(cfg *pgxpool.Config, opts []PoolOption) (*DBPool, error) {
+	this := new(DBPool)
+	pc := newPoolConfig(opts)
+	if err := pc.apply(cfg); err != nil {
+		return nil, err
+	}
+	this.statementTimeout = pc.statementTimeout
+	this.obs = pc.newObserver()
+	this.statements = pc.statements
+	this.simpleProtocol = cfg.ConnConfig.PreferSimpleProtocol
+	var e1 error
 	this.DBCon, e1 = pgxpool.ConnectConfig(CTxt, cfg)
 	if e1 != nil {
 		return this, errors.New(fmt.Sprintf("Unable to establish connection: %v", e1))
@@ -60,17 +94,18 @@ func getConnection(cparms string, tls *tls.Config) (*DBPool, error) {
 	return this, nil // success
 }
 
-// TODO : make database logging configurable
-//cfg.ConnConfig.Logger = logrusadapter.NewLogger( log.StandardLogger())
-//cfg.ConnConfig.LogLevel = pgx.LogLevelDebug
-
 // IsConnected - returns true if we have a valid connection
 func (p *DBPool) IsConnected() bool {
 	return p.connected
 }
 
-// Close - shut down the current database connection pool
+// Close - shut down the current database connection pool, including the connection used by Listen
 func (p *DBPool) Close() {
+	// Do waits for a concurrent first call to Listen to create the listener, and stops a later one creating it
+	p.listenOnce.Do(func() {})
+	if p.lsn != nil {
+		p.lsn.close()
+	}
 	if p.connected && p.DBCon != nil {
 		p.DBCon.Close()
 		p.DBCon = nil
@@ -78,36 +113,158 @@ func (p *DBPool) Close() {
 	}
 }
 
+// SetStatementTimeout - default timeout for statements run with a context that has no deadline of its own
+func (p *DBPool) SetStatementTimeout(d time.Duration) {
+	p.statementTimeout = d
+}
+
+// withTimeout - applies the pool statement timeout if ctx has no deadline, the cancel func must always be called
+func (p *DBPool) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || p.statementTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.statementTimeout)
+}
+
+// ErrNoRows - returned by the scalar getters and Select helpers when the query returns no rows
+var ErrNoRows = pgx.ErrNoRows
+
 // GetCount - execute a sql query that returns a single integer value
 func (p *DBPool) GetCount(q string, args ...interface{}) (int, error) {
-	count := 0
-	rows, err := p.DBCon.Query(CTxt, q, args...)
-	if err != nil {
-		return 0, err
-	} else {
-		rows.Next()
-		err = rows.Scan(&count)
-		if err != nil {
-			return 0, err
-		}
-		rows.Close()
-	}
-	return count, nil
+	return p.GetCountContext(CTxt, q, args...)
+}
+
+// GetCountContext - GetCount, cancelled when ctx is done. Returns ErrNoRows if the query returns no rows
+func (p *DBPool) GetCountContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	return SelectScalar[int](ctx, p, q, args...)
+}
+
+// GetInt64 - execute a sql query that returns a single integer value
+func (p *DBPool) GetInt64(ctx context.Context, q string, args ...interface{}) (int64, error) {
+	return SelectScalar[int64](ctx, p, q, args...)
+}
+
+// GetString - execute a sql query that returns a single text value
+func (p *DBPool) GetString(ctx context.Context, q string, args ...interface{}) (string, error) {
+	return SelectScalar[string](ctx, p, q, args...)
+}
+
+// GetBool - execute a sql query that returns a single boolean value
+func (p *DBPool) GetBool(ctx context.Context, q string, args ...interface{}) (bool, error) {
+	return SelectScalar[bool](ctx, p, q, args...)
+}
+
+// GetTime - execute a sql query that returns a single date / timestamp value
+func (p *DBPool) GetTime(ctx context.Context, q string, args ...interface{}) (time.Time, error) {
+	return SelectScalar[time.Time](ctx, p, q, args...)
+}
+
+// Exists - returns true if the sql query returns at least one row
+func (p *DBPool) Exists(ctx context.Context, q string, args ...interface{}) (bool, error) {
+	return SelectScalar[bool](ctx, p, existsSQL(q), args...)
+}
+
+// existsSQL - wraps q in EXISTS, allowing for a trailing semicolon or line comment
+func existsSQL(q string) string {
+	return "SELECT EXISTS (" + strings.TrimRight(q, " \t\r\n;") + "\n)"
 }
 
 func (p *DBPool) Query(q string, args ...interface{}) (pgx.Rows, error) {
-	return p.DBCon.Query(CTxt, q, args...)
+	return p.QueryContext(CTxt, q, args...)
+}
+
+// QueryContext - Query, cancelled when ctx is done. The statement timeout runs until the rows are closed
+func (p *DBPool) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	return query(ctx, p.DBCon, p.obs, cancel, q, args)
 }
 
 // Execute - execute a sql command that returns no rows (gives count of rows affected)
 func (p *DBPool) Execute(q string, args ...interface{}) (int, error) {
-	commandTag, err := p.DBCon.Exec(CTxt, q, args...)
+	return p.ExecuteContext(CTxt, q, args...)
+}
+
+// ExecuteContext - Execute, cancelled when ctx is done
+func (p *DBPool) ExecuteContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return execute(ctx, p.DBCon, p.obs, q, args)
+}
+
+// pgxQuerier - the parts of pgxpool.Pool, pgx.Conn and pgx.Tx used to run statements
+type pgxQuerier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// query - runs q, observed by obs. cancel is called once the rows are closed
+func query(ctx context.Context, db pgxQuerier, obs *observer, cancel context.CancelFunc, q string, args []interface{}) (pgx.Rows, error) {
+	ctx, done := obs.start(ctx, q, args)
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		done(0, err)
+		cancel()
+		return nil, err
+	}
+	return &trackedRows{Rows: rows, done: func() {
+		done(rows.CommandTag().RowsAffected(), rows.Err())
+		cancel()
+	}}, nil
+}
+
+// execute - runs q, observed by obs, returning the count of rows affected
+func execute(ctx context.Context, db pgxQuerier, obs *observer, q string, args []interface{}) (int, error) {
+	ctx, done := obs.start(ctx, q, args)
+	commandTag, err := db.Exec(ctx, q, args...)
+	done(commandTag.RowsAffected(), err)
 	if err == nil {
 		return int(commandTag.RowsAffected()), nil
 	}
 	return 0, err
 }
 
+// observed - presents a pgx connection as a Querier for the Select helpers, statements are observed by obs
+type observed struct {
+	db  pgxQuerier
+	obs *observer
+}
+
+func (o observed) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	return query(ctx, o.db, o.obs, func() {}, q, args)
+}
+
+func (o observed) ExecuteContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	return execute(ctx, o.db, o.obs, q, args)
+}
+
+// trackedRows - calls done once the rows are closed or exhausted, releasing the statement timeout
+type trackedRows struct {
+	pgx.Rows
+	done     func()
+	finished bool
+}
+
+func (r *trackedRows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *trackedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	// pgx closes the rows itself once they are exhausted
+	r.finish()
+	return false
+}
+
+func (r *trackedRows) finish() {
+	if !r.finished {
+		r.finished = true
+		r.done()
+	}
+}
+
 func BToI(b bool) int {
 	if b {
 		return 1
@@ -119,18 +276,22 @@ func IToB(i int) bool {
 	return i != 0
 }
 
-//IsDuplicate - returns true if err is mysql duplicate entry notification
+// IsDuplicate - returns true if err is, or wraps, a unique constraint violation
 func IsDuplicate(err error) bool {
-	me, ok := err.(*pgconn.PgError)
-	return ok && me.Code == pgerrcode.UniqueViolation
+	return KindOf(err) == KindUniqueViolation
 }
 
+// IsForeignKeyConstraint - returns true if err is, or wraps, a foreign key constraint violation
 func IsForeignKeyConstraint(err error) bool {
-	me, ok := err.(*pgconn.PgError)
-	return ok && me.Code == pgerrcode.ForeignKeyViolation
+	return KindOf(err) == KindForeignKeyViolation
 }
 
 func (p *DBPool) Optimize() error {
-	_, err := p.Execute(`Vacuum Analyze`)
+	return p.OptimizeContext(CTxt)
+}
+
+// OptimizeContext - Optimize, cancelled when ctx is done
+func (p *DBPool) OptimizeContext(ctx context.Context) error {
+	_, err := p.ExecuteContext(ctx, `Vacuum Analyze`)
 	return err
 }
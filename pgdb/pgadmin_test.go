@@ -1,10 +1,26 @@
 package pgdb
 
 import (
+	"context"
 	"github.com/cambefus/gcp_go_utils/secrets"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testPool - connects using the configuration described in the readme, the caller must Close the pool
+func testPool(t *testing.T, opts ...PoolOption) *DBPool {
+	s, e := secrets.InitializeFromEnvironment(`utilities_config`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p, e := NewExternalDBPool(s.GetString(`CLOUDSQL`), s.GetString(`TLS_CLIENT_KEY`), s.GetString(`TLS_CLIENT_CERT`), opts...)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return p
+}
+
 func Test_All(t *testing.T) {
 	s, _ := secrets.InitializeFromEnvironment(`utilities_config`)
 	cs := s.GetString(`CLOUDSQL`)
@@ -46,3 +62,64 @@ func Test_All(t *testing.T) {
 		t.Error(`unexpected maxConnections: `, mc)
 	}
 }
+
+func Test_withTimeout(t *testing.T) {
+	p := new(DBPool)
+	ctx, cancel := p.withTimeout(context.Background())
+	if _, ok := ctx.Deadline(); ok {
+		t.Error(`no deadline expected without a statement timeout`)
+	}
+	cancel()
+
+	p.SetStatementTimeout(time.Minute)
+	ctx, cancel = p.withTimeout(context.Background())
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error(`statement timeout should have been applied`)
+	}
+	cancel()
+
+	parent, pc := context.WithTimeout(context.Background(), time.Second)
+	defer pc()
+	ctx, cancel = p.withTimeout(parent)
+	if d, _ := ctx.Deadline(); time.Until(d) > time.Second {
+		t.Error(`caller deadline should take precedence`)
+	}
+	cancel()
+}
+
+func Test_existsSQL(t *testing.T) {
+	for _, q := range []string{`SELECT 1 FROM t`, "SELECT 1 FROM t;\n", `SELECT 1 FROM t -- trailing comment`} {
+		got := existsSQL(q)
+		if !strings.HasSuffix(got, "\n)") || strings.Contains(got, `;`) {
+			t.Errorf(`unexpected statement %q`, got)
+		}
+	}
+}
+
+func Test_ScalarGetters(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	if _, e := p.GetCount(`SELECT 1 WHERE false`); e != ErrNoRows {
+		t.Error(`Expected ErrNoRows for empty result, got `, e)
+	}
+	if n, e := p.GetInt64(ctx, `SELECT $1::int8`, 42); n != 42 || e != nil {
+		t.Error(`GetInt64 failed`, n, e)
+	}
+	if s, e := p.GetString(ctx, `SELECT 'abc'`); s != `abc` || e != nil {
+		t.Error(`GetString failed`, s, e)
+	}
+	if b, e := p.GetBool(ctx, `SELECT true`); !b || e != nil {
+		t.Error(`GetBool failed`, b, e)
+	}
+	if tm, e := p.GetTime(ctx, `SELECT now()`); tm.IsZero() || e != nil {
+		t.Error(`GetTime failed`, tm, e)
+	}
+	if b, e := p.Exists(ctx, `SELECT 1 FROM information_schema.tables WHERE table_name = $1`, `missing_table`); b || e != nil {
+		t.Error(`Exists failed`, b, e)
+	}
+	// every connection should have been released
+	if a := p.DBCon.Stat().AcquiredConns(); a != 0 {
+		t.Errorf(`Expected 0 acquired connections, got %d`, a)
+	}
+}
@@ -0,0 +1,364 @@
+package pgdb
+
+/*
+	durable job queue stored in postgres
+	workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, so concurrent workers never block each other or claim the
+	same job. A claimed job is leased for the visibility timeout, if its worker dies the job becomes available again once
+	the lease expires. Failed jobs are retried with backoff and moved to the dead letter table after the last attempt.
+	The tables are created by migrations recorded in their own migrations table, separate from the application's
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQueueTable - table holding jobs when QueueOptions.Table is not set, dead jobs are in <table>_dead
+const DefaultQueueTable = `pgdb_jobs`
+
+const (
+	defaultVisibilityTimeout = 5 * time.Minute
+	defaultMaxAttempts       = 5
+	defaultPollInterval      = time.Second
+	maxBackoff               = time.Hour
+)
+
+// QueueOptions - all fields are optional
+// VisibilityTimeout - how long a worker has to finish a job before it is made available again, defaults to 5 minutes.
+// The handler's context is cancelled when it expires
+// MaxAttempts - default for jobs enqueued without one, defaults to 5
+// Backoff - delay before retrying after the given (1 based) attempt fails, defaults to DefaultBackoff
+// PollInterval - how long idle workers wait before looking for jobs again, defaults to 1 second
+type QueueOptions struct {
+	Table             string
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	Backoff           func(attempt int) time.Duration
+	PollInterval      time.Duration
+}
+
+// DefaultBackoff - 2^attempt seconds, at most an hour
+func DefaultBackoff(attempt int) time.Duration {
+	d := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// EnqueueOptions - RunAt defaults to now, higher priorities run first, MaxAttempts defaults to QueueOptions.MaxAttempts
+type EnqueueOptions struct {
+	RunAt       time.Time
+	Priority    int
+	MaxAttempts int
+}
+
+// Job - a claimed job, Attempt is 1 the first time a job is run
+type Job struct {
+	ID          int64     `db:"id"`
+	Queue       string    `db:"queue"`
+	Payload     []byte    `db:"payload"`
+	Priority    int       `db:"priority"`
+	RunAt       time.Time `db:"run_at"`
+	Attempt     int       `db:"attempts"`
+	MaxAttempts int       `db:"max_attempts"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// Decode - unmarshals the json payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// DeadJob - a job that failed its last attempt, or failed permanently
+type DeadJob struct {
+	Job
+	LastError string    `db:"last_error"`
+	FailedAt  time.Time `db:"failed_at"`
+}
+
+// JobHandler - processes a job, returning an error to have it retried
+type JobHandler func(ctx context.Context, job *Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent - wraps a handler error so that the job is dead lettered without further attempts
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// QueueStats - count of jobs by state
+type QueueStats struct {
+	Ready     int64 `db:"ready"`
+	Scheduled int64 `db:"scheduled"`
+	Running   int64 `db:"running"`
+	Dead      int64 `db:"dead"`
+}
+
+// Queue - a named queue, any number of queues may share the same table
+type Queue struct {
+	pool *DBPool
+	name string
+	opts QueueOptions
+}
+
+// NewQueue - call Migrate before first use to create the tables
+func NewQueue(p *DBPool, name string, opts QueueOptions) (*Queue, error) {
+	if p == nil || len(name) == 0 {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	this := new(Queue)
+	this.pool = p
+	this.name = name
+	this.opts = opts
+	if len(this.opts.Table) == 0 {
+		this.opts.Table = DefaultQueueTable
+	}
+	if this.opts.VisibilityTimeout <= 0 {
+		this.opts.VisibilityTimeout = defaultVisibilityTimeout
+	}
+	if this.opts.MaxAttempts <= 0 {
+		this.opts.MaxAttempts = defaultMaxAttempts
+	}
+	if this.opts.Backoff == nil {
+		this.opts.Backoff = DefaultBackoff
+	}
+	if this.opts.PollInterval <= 0 {
+		this.opts.PollInterval = defaultPollInterval
+	}
+	return this, nil
+}
+
+// Migrate - creates or upgrades the queue tables, safe to call from every instance at startup
+func (q *Queue) Migrate(ctx context.Context) error {
+	m := NewMigrator(q.pool, queueMigrations(q.opts.Table), MigrateOptions{Table: q.opts.Table + `_migrations`})
+	_, err := m.Up(ctx)
+	return err
+}
+
+func queueMigrations(table string) MigrationSource {
+	t := identifier(table)
+	dead := identifier(table + `_dead`)
+	index := pgx.Identifier{strings.ReplaceAll(table, `.`, `_`) + `_ready`}.Sanitize()
+	return MigrationSourceFunc(func() ([]Migration, error) {
+		up := fmt.Sprintf(`
+CREATE TABLE %[1]s (
+	id bigserial PRIMARY KEY,
+	queue text NOT NULL,
+	payload jsonb NOT NULL,
+	priority int NOT NULL DEFAULT 0,
+	run_at timestamptz NOT NULL DEFAULT now(),
+	attempts int NOT NULL DEFAULT 0,
+	max_attempts int NOT NULL,
+	locked_until timestamptz,
+	last_error text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX %[3]s ON %[1]s (queue, priority DESC, run_at, id);
+CREATE TABLE %[2]s (
+	id bigint PRIMARY KEY,
+	queue text NOT NULL,
+	payload jsonb NOT NULL,
+	priority int NOT NULL,
+	run_at timestamptz NOT NULL,
+	attempts int NOT NULL,
+	max_attempts int NOT NULL,
+	last_error text NOT NULL,
+	created_at timestamptz NOT NULL,
+	failed_at timestamptz NOT NULL DEFAULT now()
+);`, t, dead, index)
+		down := fmt.Sprintf(`DROP TABLE %s; DROP TABLE %s;`, dead, t)
+		return []Migration{{Version: 1, Name: `create_jobs`, Up: up, Down: down, Checksum: checksumSQL(up)}}, nil
+	})
+}
+
+// Enqueue - adds a job, payload is stored as json. Returns the job id
+func (q *Queue) Enqueue(ctx context.Context, payload interface{}, opts EnqueueOptions) (int64, error) {
+	return q.enqueue(ctx, q.pool, payload, opts)
+}
+
+// EnqueueTx - Enqueue within tx, the job only becomes visible if tx commits
+func (q *Queue) EnqueueTx(ctx context.Context, tx *Tx, payload interface{}, opts EnqueueOptions) (int64, error) {
+	return q.enqueue(ctx, tx, payload, opts)
+}
+
+func (q *Queue) enqueue(ctx context.Context, db Querier, payload interface{}, opts EnqueueOptions) (int64, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = q.opts.MaxAttempts
+	}
+	var runAt interface{}
+	if !opts.RunAt.IsZero() {
+		runAt = opts.RunAt
+	}
+	return SelectScalar[int64](ctx, db, `INSERT INTO `+q.table()+` (queue, payload, priority, run_at, max_attempts)
+		VALUES ($1, $2, $3, COALESCE($4, now()), $5) RETURNING id`,
+		q.name, string(b), opts.Priority, runAt, opts.MaxAttempts)
+}
+
+// Work - runs handler for jobs as they become due, with at most concurrency jobs in progress
+// blocks until ctx is done, then waits for jobs in progress to finish. Their contexts are cancelled, and a job whose
+// handler fails as a result is released for another worker without counting the attempt
+func (q *Queue) Work(ctx context.Context, concurrency int, handler JobHandler) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for ctx.Err() == nil {
+		free := concurrency - len(slots)
+		var jobs []Job
+		var err error
+		if free > 0 {
+			jobs, err = q.claim(ctx, free)
+			if err != nil && ctx.Err() == nil {
+				q.pool.logWarn(`unable to claim jobs`, err)
+			}
+		}
+		for i := range jobs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(job *Job) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				q.process(ctx, job, handler)
+			}(&jobs[i])
+		}
+		if len(jobs) > 0 && len(jobs) == free {
+			// there may be more work waiting, look again as soon as a slot is free
+			select {
+			case <-ctx.Done():
+			case slots <- struct{}{}:
+				<-slots
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(q.opts.PollInterval):
+		}
+	}
+}
+
+// leaseExpiredError - recorded for jobs whose worker never reported the outcome of their final attempt
+const leaseExpiredError = `lease expired on the final attempt without an outcome being recorded`
+
+// claim - leases up to n due jobs
+// jobs whose lease expired on their final attempt, e.g. because the worker crashed, are dead lettered first
+func (q *Queue) claim(ctx context.Context, n int) ([]Job, error) {
+	var result []Job
+	err := q.pool.WithTx(ctx, TxOptions{}, func(tx *Tx) error {
+		_, err := tx.ExecuteContext(ctx, fmt.Sprintf(`WITH d AS (DELETE FROM %s
+				WHERE queue = $1 AND locked_until < now() AND attempts >= max_attempts RETURNING *)
+			INSERT INTO %s (id, queue, payload, priority, run_at, attempts, max_attempts, last_error, created_at)
+			SELECT id, queue, payload, priority, run_at, attempts, max_attempts, $2, created_at FROM d`,
+			q.table(), identifier(q.opts.Table+`_dead`)), q.name, leaseExpiredError)
+		if err != nil {
+			return err
+		}
+		result, err = SelectAll[Job](ctx, tx, fmt.Sprintf(`UPDATE %[1]s SET
+			locked_until = now() + $2 * interval '1 millisecond', attempts = attempts + 1
+			WHERE id IN (SELECT id FROM %[1]s
+				WHERE queue = $1 AND run_at <= now()
+					AND (locked_until IS NULL OR (locked_until < now() AND attempts < max_attempts))
+				ORDER BY priority DESC, run_at, id LIMIT $3 FOR UPDATE SKIP LOCKED)
+			RETURNING id, queue, payload, priority, run_at, attempts, max_attempts, created_at`, q.table()),
+			q.name, q.opts.VisibilityTimeout.Milliseconds(), n)
+		return err
+	})
+	return result, err
+}
+
+// process - runs the handler and records the outcome
+// the attempt count identifies the lease, so a worker whose lease has expired cannot change a job reclaimed by another
+func (q *Queue) process(ctx context.Context, job *Job, handler JobHandler) {
+	err := runJob(ctx, q.opts.VisibilityTimeout, job, handler)
+	// the outcome is recorded even if the worker is shutting down
+	done, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var perm *permanentError
+	switch {
+	case err == nil:
+		_, err = q.pool.ExecuteContext(done, `DELETE FROM `+q.table()+` WHERE id = $1 AND attempts = $2`, job.ID, job.Attempt)
+	case ctx.Err() != nil:
+		_, err = q.pool.ExecuteContext(done, `UPDATE `+q.table()+` SET locked_until = NULL, attempts = attempts - 1
+			WHERE id = $1 AND attempts = $2`, job.ID, job.Attempt)
+	case job.Attempt >= job.MaxAttempts || errors.As(err, &perm):
+		_, err = q.pool.ExecuteContext(done, fmt.Sprintf(`WITH d AS (DELETE FROM %s WHERE id = $1 AND attempts = $2 RETURNING *)
+			INSERT INTO %s (id, queue, payload, priority, run_at, attempts, max_attempts, last_error, created_at)
+			SELECT id, queue, payload, priority, run_at, attempts, max_attempts, $3, created_at FROM d`,
+			q.table(), identifier(q.opts.Table+`_dead`)), job.ID, job.Attempt, err.Error())
+	default:
+		_, err = q.pool.ExecuteContext(done, `UPDATE `+q.table()+` SET locked_until = NULL, last_error = $3,
+			run_at = now() + $4 * interval '1 millisecond' WHERE id = $1 AND attempts = $2`,
+			job.ID, job.Attempt, err.Error(), q.opts.Backoff(job.Attempt).Milliseconds())
+	}
+	if err != nil {
+		q.pool.logWarn(`unable to record job outcome`, err)
+	}
+}
+
+// runJob - the handler's error, with panics recovered as errors
+func runJob(ctx context.Context, timeout time.Duration, job *Job, handler JobHandler) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(`job panicked: %v`, r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// Stats - jobs ready to run, scheduled for later, in progress and dead
+func (q *Queue) Stats(ctx context.Context) (QueueStats, error) {
+	return SelectOne[QueueStats](ctx, q.pool, fmt.Sprintf(`SELECT
+		count(*) FILTER (WHERE run_at <= now() AND (locked_until IS NULL OR locked_until < now())) AS ready,
+		count(*) FILTER (WHERE run_at > now() AND (locked_until IS NULL OR locked_until < now())) AS scheduled,
+		count(*) FILTER (WHERE locked_until >= now()) AS running,
+		(SELECT count(*) FROM %s WHERE queue = $1) AS dead
+		FROM %s WHERE queue = $1`, identifier(q.opts.Table+`_dead`), q.table()), q.name)
+}
+
+// DeadJobs - the most recently failed jobs, at most limit
+func (q *Queue) DeadJobs(ctx context.Context, limit int) ([]DeadJob, error) {
+	return SelectAll[DeadJob](ctx, q.pool, `SELECT id, queue, payload, priority, run_at, attempts, max_attempts,
+		last_error, created_at, failed_at FROM `+identifier(q.opts.Table+`_dead`)+`
+		WHERE queue = $1 ORDER BY failed_at DESC LIMIT $2`, q.name, limit)
+}
+
+// Requeue - moves a dead job back to the queue with its attempts reset, returns false if there is no such dead job
+func (q *Queue) Requeue(ctx context.Context, id int64) (bool, error) {
+	n, err := q.pool.ExecuteContext(ctx, fmt.Sprintf(`WITH d AS (DELETE FROM %s WHERE id = $1 AND queue = $2 RETURNING *)
+		INSERT INTO %s (id, queue, payload, priority, max_attempts, last_error, created_at)
+		SELECT id, queue, payload, priority, max_attempts, last_error, created_at FROM d`,
+		identifier(q.opts.Table+`_dead`), q.table()), id, q.name)
+	return n > 0, err
+}
+
+func (q *Queue) table() string {
+	return identifier(q.opts.Table)
+}
@@ -0,0 +1,139 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_NewQueue(t *testing.T) {
+	if _, e := NewQueue(new(DBPool), ``, QueueOptions{}); e == nil {
+		t.Error(`Expected error without a name`)
+	}
+	q, e := NewQueue(new(DBPool), `mail`, QueueOptions{Table: `work.jobs`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if q.opts.VisibilityTimeout != defaultVisibilityTimeout || q.opts.MaxAttempts != defaultMaxAttempts || q.table() != `"work"."jobs"` {
+		t.Errorf(`unexpected options %+v`, q.opts)
+	}
+	all, _ := queueMigrations(q.opts.Table).Migrations()
+	if len(all) != 1 || !strings.Contains(all[0].Up, `"work"."jobs_dead"`) || !strings.Contains(all[0].Up, `"work_jobs_ready"`) {
+		t.Errorf(`unexpected migrations %+v`, all)
+	}
+}
+
+func Test_DefaultBackoff(t *testing.T) {
+	if DefaultBackoff(1) != 2*time.Second || DefaultBackoff(3) != 8*time.Second || DefaultBackoff(100) != maxBackoff {
+		t.Error(`unexpected backoff`)
+	}
+}
+
+func Test_runJob(t *testing.T) {
+	e := runJob(context.Background(), time.Minute, &Job{}, func(ctx context.Context, job *Job) error { panic(`boom`) })
+	if e == nil || !strings.Contains(e.Error(), `boom`) {
+		t.Errorf(`Expected panic to be returned as an error, got %v`, e)
+	}
+	e = runJob(context.Background(), time.Millisecond, &Job{}, func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(e, context.DeadlineExceeded) {
+		t.Errorf(`Expected visibility timeout, got %v`, e)
+	}
+	var perm *permanentError
+	if e = Permanent(errors.New(`bad`)); !errors.As(e, &perm) || e.Error() != `bad` {
+		t.Error(`unexpected permanent error`, e)
+	}
+}
+
+func Test_jobPayload(t *testing.T) {
+	now := `2024-01-02 03:04:05+00`
+	cols := []string{`id`, `queue`, `payload`, `priority`, `run_at`, `attempts`, `max_attempts`, `created_at`}
+	oids := []uint32{pgtype.Int8OID, pgtype.TextOID, pgtype.JSONBOID, pgtype.Int4OID, pgtype.TimestamptzOID,
+		pgtype.Int4OID, pgtype.Int4OID, pgtype.TimestamptzOID}
+	q := fakeQuerier{&fakeRows{cols: cols, oids: oids,
+		data: [][]interface{}{{`7`, `mail`, `{"n": 1}`, `0`, now, `1`, `5`, now}, {`8`, `mail`, `[1, 2]`, `0`, now, `1`, `5`, now}}}}
+	jobs, e := SelectAll[Job](context.Background(), q, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var v struct{ N int }
+	if len(jobs) != 2 || jobs[0].Decode(&v) != nil || v.N != 1 || string(jobs[1].Payload) != `[1, 2]` {
+		t.Errorf(`unexpected jobs %+v`, jobs)
+	}
+
+	q = fakeQuerier{&fakeRows{cols: append(cols, `last_error`, `failed_at`), oids: append(oids, pgtype.TextOID, pgtype.TimestamptzOID),
+		data: [][]interface{}{{`7`, `mail`, `{"n": 2}`, `0`, now, `5`, `5`, now, `failed`, now}}}}
+	dead, e := SelectAll[DeadJob](context.Background(), q, ``)
+	if e != nil || len(dead) != 1 || dead[0].Decode(&v) != nil || v.N != 2 || dead[0].LastError != `failed` {
+		t.Errorf(`unexpected dead jobs %+v %v`, dead, e)
+	}
+}
+
+func Test_Queue(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	q, _ := NewQueue(p, `test`, QueueOptions{Table: `queue_test`, PollInterval: 50 * time.Millisecond,
+		Backoff: func(int) time.Duration { return 0 }})
+	defer p.Execute(`drop table if exists queue_test, queue_test_dead, queue_test_migrations`)
+	if e := q.Migrate(ctx); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := q.Enqueue(ctx, map[string]int{`n`: 1}, EnqueueOptions{}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := q.Enqueue(ctx, map[string]int{`n`: 2}, EnqueueOptions{MaxAttempts: 2}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := q.Enqueue(ctx, map[string]int{`n`: 3}, EnqueueOptions{RunAt: time.Now().Add(time.Hour)}); e != nil {
+		t.Fatal(e)
+	}
+
+	var done int32
+	wctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	q.Work(wctx, 2, func(ctx context.Context, job *Job) error {
+		var v struct{ N int }
+		if e := job.Decode(&v); e != nil {
+			return Permanent(e)
+		}
+		if v.N == 2 {
+			return errors.New(`always fails`)
+		}
+		atomic.AddInt32(&done, 1)
+		return nil
+	})
+	st, e := q.Stats(ctx)
+	if e != nil || done != 1 || st.Scheduled != 1 || st.Dead != 1 || st.Ready != 0 {
+		t.Error(`unexpected queue state`, done, st, e)
+	}
+	dead, e := q.DeadJobs(ctx, 10)
+	if e != nil || len(dead) != 1 || dead[0].Attempt != 2 || dead[0].LastError != `always fails` {
+		t.Error(`unexpected dead jobs`, dead, e)
+	}
+	if ok, e := q.Requeue(ctx, dead[0].ID); !ok || e != nil {
+		t.Error(`Requeue failed`, e)
+	}
+	// a worker that never reports the outcome of the final attempt, e.g. one that crashed
+	lost, _ := NewQueue(p, `lost`, QueueOptions{Table: `queue_test`, VisibilityTimeout: 100 * time.Millisecond})
+	if _, e = lost.Enqueue(ctx, `x`, EnqueueOptions{MaxAttempts: 1}); e != nil {
+		t.Fatal(e)
+	}
+	if jobs, e := lost.claim(ctx, 1); e != nil || len(jobs) != 1 {
+		t.Fatal(`Expected to claim the job`, jobs, e)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if jobs, e := lost.claim(ctx, 1); e != nil || len(jobs) != 0 {
+		t.Error(`a job should not be claimed again after its final attempt`, jobs, e)
+	}
+	dead, e = lost.DeadJobs(ctx, 10)
+	if e != nil || len(dead) != 1 || dead[0].LastError != leaseExpiredError {
+		t.Error(`Expected the job to be dead lettered`, dead, e)
+	}
+}
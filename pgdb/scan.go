@@ -0,0 +1,245 @@
+package pgdb
+
+/*
+	row mapping helpers, removing the need for hand written rows.Next() / Scan loops
+	columns are matched to struct fields using the `db` tag, or the lower case field name when there is no tag
+	a tag of `db:"-"` excludes the field. Fields of embedded structs are treated as fields of the outer struct
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Querier - implemented by both DBPool and Tx, so the helpers work inside and outside of transactions
+type Querier interface {
+	QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error)
+}
+
+// UnknownColumnError - the query returned a column that has no matching field in the destination struct
+type UnknownColumnError struct {
+	Column string
+	Type   string
+}
+
+func (e *UnknownColumnError) Error() string {
+	return fmt.Sprintf(`column "%s" has no matching field in %s`, e.Column, e.Type)
+}
+
+// SelectAll - runs the query and maps every row to a T, which must be a struct
+func SelectAll[T any](ctx context.Context, db Querier, q string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]T, 0)
+	for rows.Next() {
+		var v T
+		if err = scanStruct(rows, &v); err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// SelectOne - runs the query and maps the first row to a T, which must be a struct
+// returns ErrNoRows if the query returns no rows
+func SelectOne[T any](ctx context.Context, db Querier, q string, args ...interface{}) (T, error) {
+	var result T
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return result, err
+		}
+		return result, ErrNoRows
+	}
+	err = scanStruct(rows, &result)
+	return result, err
+}
+
+// SelectScalar - runs a query that returns a single value, use a pointer type for T if the value may be NULL
+// the rows are always closed, releasing the connection. Returns ErrNoRows if the query returns no rows
+func SelectScalar[T any](ctx context.Context, db Querier, q string, args ...interface{}) (T, error) {
+	var result T
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return result, err
+		}
+		return result, ErrNoRows
+	}
+	err = rows.Scan(&result)
+	return result, err
+}
+
+// SelectMap - runs the query and returns each row as a map of column name to value, NULL values are nil
+func SelectMap(ctx context.Context, db Querier, q string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fd := rows.FieldDescriptions()
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		vals, e1 := rows.Values()
+		if e1 != nil {
+			return nil, e1
+		}
+		m := make(map[string]interface{}, len(fd))
+		for i, f := range fd {
+			m[string(f.Name)] = vals[i]
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// scanStruct - scan the current row into the struct pointed to by dest
+func scanStruct(rows pgx.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest).Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf(`destination must be a struct, not %s`, v.Type())
+	}
+	si := getStructInfo(v.Type())
+	if si.err != nil {
+		return si.err
+	}
+	fields := si.paths
+	fd := rows.FieldDescriptions()
+	targets := make([]interface{}, len(fd))
+	// other non pointer fields are scanned through a temporary pointer so that NULL becomes the zero value
+	nullable := make([]reflect.Value, len(fd))
+	for i, f := range fd {
+		path, ok := fields[string(f.Name)]
+		if !ok {
+			return &UnknownColumnError{Column: string(f.Name), Type: v.Type().String()}
+		}
+		fv := fieldByPath(v, path)
+		if fv.Kind() == reflect.Ptr || scanDirect(fv.Type()) {
+			targets[i] = fv.Addr().Interface()
+		} else {
+			nullable[i] = reflect.New(reflect.PtrTo(fv.Type()))
+			targets[i] = nullable[i].Interface()
+		}
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return err
+	}
+	for i, f := range fd {
+		if nullable[i].IsValid() {
+			fv := fieldByPath(v, fields[string(f.Name)])
+			if p := nullable[i].Elem(); p.IsNil() {
+				fv.Set(reflect.Zero(fv.Type()))
+			} else {
+				fv.Set(p.Elem())
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	bytesType         = reflect.TypeOf([]byte(nil))
+	scannerType       = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	textDecoderType   = reflect.TypeOf((*pgtype.TextDecoder)(nil)).Elem()
+	binaryDecoderType = reflect.TypeOf((*pgtype.BinaryDecoder)(nil)).Elem()
+)
+
+// scanDirect - types that handle NULL themselves, and that pgx only decodes correctly through a single pointer,
+// e.g. json into a []byte
+func scanDirect(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return t == bytesType || pt.Implements(scannerType) || pt.Implements(textDecoderType) || pt.Implements(binaryDecoderType)
+}
+
+// fieldByPath - like reflect.Value.FieldByIndex, but allocates nil embedded struct pointers
+func fieldByPath(v reflect.Value, path []int) reflect.Value {
+	for i, x := range path {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+var fieldCache sync.Map
+
+// structInfo - column name to field index path for a struct type, columns are in field declaration order
+// err is set for structs that cannot be scanned into
+type structInfo struct {
+	paths   map[string][]int
+	columns []string
+	err     error
+}
+
+// structFields - map of column name to field index path for the struct type, cached per type
+func structFields(t reflect.Type) map[string][]int {
+	return getStructInfo(t).paths
+}
+
+func getStructInfo(t reflect.Type) *structInfo {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structInfo)
+	}
+	result := &structInfo{paths: make(map[string][]int)}
+	result.addFields(t, nil)
+	fieldCache.Store(t, result)
+	return result
+}
+
+func (si *structInfo) addFields(t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(`db`)
+		if tag == `-` {
+			continue
+		}
+		path := append(append([]int{}, parent...), i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && len(tag) == 0 && ft.Kind() == reflect.Struct {
+			if f.Type.Kind() == reflect.Ptr && len(f.PkgPath) > 0 && si.err == nil {
+				// reflect cannot allocate an unexported embedded pointer, so its fields could never be set
+				si.err = fmt.Errorf(`embedded field %s of %s is a pointer to an unexported type, embed it by value`, f.Name, t)
+			}
+			si.addFields(ft, path)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue // unexported
+		}
+		if len(tag) == 0 {
+			tag = strings.ToLower(f.Name)
+		}
+		// fields of the outer struct take precedence over embedded ones
+		if existing, ok := si.paths[tag]; !ok {
+			si.columns = append(si.columns, tag)
+			si.paths[tag] = path
+		} else if len(existing) > len(path) {
+			si.paths[tag] = path
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package pgdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"reflect"
+	"testing"
+)
+
+// fakeRows - minimal in memory pgx.Rows, NULL is represented by nil
+// when oids is set, data holds the text format values and Scan decodes them with pgtype as pgx does
+type fakeRows struct {
+	cols []string
+	data [][]interface{}
+	oids []uint32
+	pos  int
+}
+
+func (r *fakeRows) Close()                        {}
+func (r *fakeRows) Err() error                    { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag { return nil }
+func (r *fakeRows) RawValues() [][]byte           { return nil }
+func (r *fakeRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.data)
+}
+func (r *fakeRows) FieldDescriptions() []pgproto3.FieldDescription {
+	result := make([]pgproto3.FieldDescription, len(r.cols))
+	for i, c := range r.cols {
+		result[i].Name = []byte(c)
+	}
+	return result
+}
+func (r *fakeRows) Values() ([]interface{}, error) {
+	return r.data[r.pos-1], nil
+}
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.oids != nil {
+		ci := pgtype.NewConnInfo()
+		for i, d := range dest {
+			var src []byte
+			if v, ok := r.data[r.pos-1][i].(string); ok {
+				src = []byte(v)
+			}
+			if err := ci.Scan(r.oids[i], pgtype.TextFormatCode, src, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, d := range dest {
+		dv := reflect.ValueOf(d).Elem()
+		src := r.data[r.pos-1][i]
+		switch {
+		case src == nil:
+			dv.Set(reflect.Zero(dv.Type()))
+		case dv.Kind() == reflect.Ptr:
+			p := reflect.New(dv.Type().Elem())
+			p.Elem().Set(reflect.ValueOf(src))
+			dv.Set(p)
+		default:
+			dv.Set(reflect.ValueOf(src))
+		}
+	}
+	return nil
+}
+
+type fakeQuerier struct {
+	rows *fakeRows
+}
+
+func (f fakeQuerier) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	return f.rows, nil
+}
+
+type audit struct {
+	Created string `db:"created_at"`
+}
+
+type person struct {
+	audit
+	ID       int `db:"id"`
+	Name     string
+	Nickname *string `db:"nick"`
+	Ignored  string  `db:"-"`
+}
+
+func Test_SelectAll(t *testing.T) {
+	q := fakeQuerier{&fakeRows{
+		cols: []string{`id`, `name`, `nick`, `created_at`},
+		data: [][]interface{}{{1, `Bilbo`, `Burglar`, `today`}, {2, nil, nil, nil}},
+	}}
+	got, e := SelectAll[person](context.Background(), q, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(got) != 2 || got[0].Name != `Bilbo` || *got[0].Nickname != `Burglar` || got[0].Created != `today` {
+		t.Errorf(`unexpected result %+v`, got)
+	}
+	if got[1].ID != 2 || got[1].Name != `` || got[1].Nickname != nil {
+		t.Errorf(`NULL columns not mapped to zero values %+v`, got[1])
+	}
+}
+
+func Test_SelectOne(t *testing.T) {
+	q := fakeQuerier{&fakeRows{cols: []string{`id`, `unknown`}, data: [][]interface{}{{1, 2}}}}
+	_, e := SelectOne[person](context.Background(), q, ``)
+	var uc *UnknownColumnError
+	if !errors.As(e, &uc) || uc.Column != `unknown` {
+		t.Errorf(`Expected unknown column error, got %v`, e)
+	}
+	q = fakeQuerier{&fakeRows{cols: []string{`id`}}}
+	if _, e = SelectOne[person](context.Background(), q, ``); e != ErrNoRows {
+		t.Errorf(`Expected no rows, got %v`, e)
+	}
+}
+
+func Test_SelectScalarAndMap(t *testing.T) {
+	q := fakeQuerier{&fakeRows{cols: []string{`count`}, data: [][]interface{}{{int64(42)}}}}
+	n, e := SelectScalar[int64](context.Background(), q, ``)
+	if e != nil || n != 42 {
+		t.Errorf(`Expected 42, got %d %v`, n, e)
+	}
+	q = fakeQuerier{&fakeRows{cols: []string{`a`, `b`}, data: [][]interface{}{{1, nil}}}}
+	m, e := SelectMap(context.Background(), q, ``)
+	if e != nil || len(m) != 1 || m[0][`a`] != 1 || m[0][`b`] != nil {
+		t.Errorf(`unexpected map result %v %v`, m, e)
+	}
+}
+
+type document struct {
+	ID   int64          `db:"id"`
+	Body []byte         `db:"body"`
+	Note sql.NullString `db:"note"`
+	Tags pgtype.JSONB   `db:"tags"`
+}
+
+func Test_scanDirect(t *testing.T) {
+	q := fakeQuerier{&fakeRows{
+		cols: []string{`id`, `body`, `note`, `tags`},
+		oids: []uint32{pgtype.Int8OID, pgtype.JSONBOID, pgtype.TextOID, pgtype.JSONBOID},
+		data: [][]interface{}{{`1`, `{"a": 1}`, nil, `["x"]`}, {`2`, nil, `n`, nil}},
+	}}
+	got, e := SelectAll[document](context.Background(), q, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(got[0].Body) != `{"a": 1}` || got[0].Note.Valid || string(got[0].Tags.Bytes) != `["x"]` {
+		t.Errorf(`unexpected result %+v`, got[0])
+	}
+	if got[1].Body != nil || got[1].Note.String != `n` || got[1].Tags.Status != pgtype.Null {
+		t.Errorf(`unexpected result %+v`, got[1])
+	}
+}
+
+type auditedPerson struct {
+	*audit
+	ID int `db:"id"`
+}
+
+func Test_unexportedEmbeddedPointer(t *testing.T) {
+	q := fakeQuerier{&fakeRows{cols: []string{`id`, `created_at`}, data: [][]interface{}{{1, `today`}}}}
+	if _, e := SelectOne[auditedPerson](context.Background(), q, ``); e == nil {
+		t.Error(`Expected error for an unexported embedded pointer`)
+	}
+}
@@ -0,0 +1,139 @@
+package pgdb
+
+/*
+	named prepared statements and control over pgx's statement caching
+	statements registered with WithPreparedStatement are prepared on every connection as it is opened, and run by name
+	with QueryNamed / ExecuteNamed. With the simple protocol nothing is prepared, the statement text is sent instead,
+	as required when connecting through a transaction pooling proxy such as PgBouncer
+*/
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+)
+
+// StatementCacheMode - how pgx handles statements that are not explicitly prepared
+type StatementCacheMode int
+
+const (
+	// CachePrepare - statements are prepared on first use and the prepared statement reused, the pgx default
+	CachePrepare StatementCacheMode = iota
+	// CacheDescribe - only the description of each statement is cached, statements are parsed on every execution.
+	// Avoids server side prepared statements, but still uses the extended protocol
+	CacheDescribe
+	// SimpleProtocol - statements are sent as text with the arguments interpolated client side, nothing is prepared
+	SimpleProtocol
+)
+
+// DefaultStatementCacheSize - statements cached per connection when WithStatementCache is given a capacity of zero
+const DefaultStatementCacheSize = 512
+
+// UnknownStatementError - QueryNamed or ExecuteNamed was given a name not registered with WithPreparedStatement
+type UnknownStatementError struct {
+	Name string
+}
+
+func (e *UnknownStatementError) Error() string {
+	return fmt.Sprintf(`no prepared statement named %q`, e.Name)
+}
+
+// WithStatementCache - selects the statement cache mode, capacity is the number of statements cached per connection
+// and is ignored for SimpleProtocol. Overrides statement_cache_mode and prefer_simple_protocol in the connection string
+func WithStatementCache(mode StatementCacheMode, capacity int) PoolOption {
+	return func(c *poolConfig) {
+		c.cacheMode = mode
+		c.cacheSize = capacity
+		c.cacheSet = true
+	}
+}
+
+// WithPreparedStatement - sql is prepared as name on every connection, may be given any number of times
+// a statement that fails to prepare prevents connections being opened, so errors show up when the pool is created
+func WithPreparedStatement(name, sql string) PoolOption {
+	return func(c *poolConfig) {
+		if c.statements == nil {
+			c.statements = make(map[string]string)
+		}
+		c.statements[name] = sql
+	}
+}
+
+// applyStatements - configures the statement cache and prepares the registered statements on connect
+func (c *poolConfig) applyStatements(cc *pgx.ConnConfig) {
+	if c.cacheSet {
+		size := c.cacheSize
+		if size <= 0 {
+			size = DefaultStatementCacheSize
+		}
+		switch c.cacheMode {
+		case SimpleProtocol:
+			cc.PreferSimpleProtocol = true
+			cc.BuildStatementCache = nil
+		case CacheDescribe:
+			cc.PreferSimpleProtocol = false
+			cc.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+				return stmtcache.New(conn, stmtcache.ModeDescribe, size)
+			}
+		default:
+			cc.PreferSimpleProtocol = false
+			cc.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+				return stmtcache.New(conn, stmtcache.ModePrepare, size)
+			}
+		}
+	}
+	// the simple protocol may also have been chosen in the connection string, e.g. prefer_simple_protocol=true
+	if len(c.statements) > 0 && !cc.PreferSimpleProtocol {
+		statements := c.statements
+		c.afterConnect = append([]func(context.Context, *pgx.Conn) error{func(ctx context.Context, conn *pgx.Conn) error {
+			for name, sql := range statements {
+				if _, err := conn.Prepare(ctx, name, sql); err != nil {
+					return fmt.Errorf(`unable to prepare %s: %w`, name, err)
+				}
+			}
+			return nil
+		}}, c.afterConnect...)
+	}
+}
+
+// QueryNamed - runs the statement registered as name
+func (p *DBPool) QueryNamed(ctx context.Context, name string, args ...interface{}) (pgx.Rows, error) {
+	q, err := p.namedStatement(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.QueryContext(ctx, q, args...)
+}
+
+// ExecuteNamed - runs the statement registered as name, returning the count of rows affected
+func (p *DBPool) ExecuteNamed(ctx context.Context, name string, args ...interface{}) (int, error) {
+	q, err := p.namedStatement(name)
+	if err != nil {
+		return 0, err
+	}
+	return p.ExecuteContext(ctx, q, args...)
+}
+
+// Statements - the names of the registered statements and their sql
+func (p *DBPool) Statements() map[string]string {
+	result := make(map[string]string, len(p.statements))
+	for k, v := range p.statements {
+		result[k] = v
+	}
+	return result
+}
+
+// namedStatement - what to send to pgx: the name of a prepared statement, which pgx recognises, or with the
+// simple protocol the statement's text
+func (p *DBPool) namedStatement(name string) (string, error) {
+	sql, ok := p.statements[name]
+	if !ok {
+		return ``, &UnknownStatementError{Name: name}
+	}
+	if p.simpleProtocol {
+		return sql, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,77 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"testing"
+)
+
+func Test_applyStatements(t *testing.T) {
+	cfg, _ := pgxpool.ParseConfig(`host=localhost dbname=test`)
+	pc := newPoolConfig([]PoolOption{WithStatementCache(CacheDescribe, 0), WithPreparedStatement(`one`, `SELECT 1`)})
+	pc.apply(cfg)
+	if cfg.ConnConfig.BuildStatementCache == nil || cfg.ConnConfig.PreferSimpleProtocol {
+		t.Error(`Expected a describe statement cache`)
+	}
+	if cfg.AfterConnect == nil || len(pc.afterConnect) != 1 {
+		t.Error(`Expected an AfterConnect hook preparing the statements`)
+	}
+
+	cfg, _ = pgxpool.ParseConfig(`host=localhost dbname=test`)
+	pc = newPoolConfig([]PoolOption{WithStatementCache(SimpleProtocol, 0), WithPreparedStatement(`one`, `SELECT 1`)})
+	pc.apply(cfg)
+	if cfg.ConnConfig.BuildStatementCache != nil || !cfg.ConnConfig.PreferSimpleProtocol {
+		t.Error(`Expected the simple protocol without a statement cache`)
+	}
+	if cfg.AfterConnect != nil {
+		t.Error(`Expected nothing to be prepared with the simple protocol`)
+	}
+
+	cfg, _ = pgxpool.ParseConfig(`host=localhost dbname=test prefer_simple_protocol=true`)
+	pc = newPoolConfig([]PoolOption{WithPreparedStatement(`one`, `SELECT 1`)})
+	pc.apply(cfg)
+	if cfg.AfterConnect != nil {
+		t.Error(`Expected nothing to be prepared with the simple protocol from the connection string`)
+	}
+
+	cfg, _ = pgxpool.ParseConfig(`host=localhost dbname=test prefer_simple_protocol=true`)
+	pc = newPoolConfig([]PoolOption{WithStatementCache(CachePrepare, 0), WithPreparedStatement(`one`, `SELECT 1`)})
+	pc.apply(cfg)
+	if cfg.ConnConfig.PreferSimpleProtocol || cfg.AfterConnect == nil {
+		t.Error(`Expected WithStatementCache to override the connection string`)
+	}
+}
+
+func Test_namedStatement(t *testing.T) {
+	p := &DBPool{statements: map[string]string{`one`: `SELECT 1`}}
+	if q, err := p.namedStatement(`one`); err != nil || q != `one` {
+		t.Errorf(`Expected the statement name, got %q %v`, q, err)
+	}
+	p.simpleProtocol = true
+	if q, err := p.namedStatement(`one`); err != nil || q != `SELECT 1` {
+		t.Errorf(`Expected the statement text, got %q %v`, q, err)
+	}
+	var ue *UnknownStatementError
+	if _, err := p.namedStatement(`two`); !errors.As(err, &ue) || ue.Name != `two` {
+		t.Errorf(`Expected an UnknownStatementError, got %v`, err)
+	}
+}
+
+func Test_PreparedStatements(t *testing.T) {
+	p := testPool(t, WithPreparedStatement(`add`, `SELECT $1::int + $2::int`))
+	defer p.Close()
+	ctx := context.Background()
+	rows, err := p.QueryNamed(ctx, `add`, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var sum int
+	if !rows.Next() {
+		t.Fatal(`Expected a row`)
+	}
+	if err = rows.Scan(&sum); err != nil || sum != 5 {
+		t.Errorf(`Expected 5, got %d %v`, sum, err)
+	}
+}
@@ -0,0 +1,114 @@
+package pgdb
+
+/*
+	TLS configuration for connections that are not made through the Cloud SQL auth proxy
+	certificate material is supplied as PEM bytes, so it can come from files or from secrets.GetFile
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+type TLSMode int
+
+const (
+	// TLSVerifyFull - the server certificate must chain to the CA and match the host (or ServerName)
+	TLSVerifyFull TLSMode = iota
+	// TLSVerifyCA - the server certificate must chain to the CA, the name is not checked
+	TLSVerifyCA
+	// TLSInsecure - the server certificate is not checked at all
+	TLSInsecure
+)
+
+// TLSOptions - ServerCA is required for TLSVerifyCA, TLSVerifyFull falls back to the system roots without it
+// ClientCert / ClientKey are optional, and needed when the server requires client certificates (as Cloud SQL does)
+// ServerName - name expected in the server certificate, for Cloud SQL this is "project:instance"
+// the certificate common name is accepted as well as the subject alternative names
+type TLSOptions struct {
+	Mode       TLSMode
+	ServerCA   []byte
+	ClientCert []byte
+	ClientKey  []byte
+	ServerName string
+}
+
+// TLSOptionsFromFiles - reads PEM encoded certificate material, any blank path is skipped
+func TLSOptionsFromFiles(mode TLSMode, serverCA, clientCert, clientKey string) (TLSOptions, error) {
+	result := TLSOptions{Mode: mode}
+	files := []struct {
+		path string
+		dest *[]byte
+	}{{serverCA, &result.ServerCA}, {clientCert, &result.ClientCert}, {clientKey, &result.ClientKey}}
+	for _, f := range files {
+		if len(f.path) == 0 {
+			continue
+		}
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return result, err
+		}
+		*f.dest = b
+	}
+	return result, nil
+}
+
+// NewTLSConfig - builds a tls.Config for the given options
+func NewTLSConfig(o TLSOptions) (*tls.Config, error) {
+	result := new(tls.Config)
+	if len(o.ClientCert) > 0 || len(o.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	// all modes perform their own verification, so that the certificate common name can be accepted
+	result.InsecureSkipVerify = true
+	if o.Mode == TLSInsecure {
+		return result, nil
+	}
+
+	var roots *x509.CertPool
+	if len(o.ServerCA) > 0 {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(o.ServerCA) {
+			return nil, errors.New(`unable to parse server CA certificate`)
+		}
+	} else if o.Mode == TLSVerifyCA {
+		return nil, errors.New(`server CA certificate required for verify-ca`)
+	}
+	result.ServerName = o.ServerName
+	mode := o.Mode
+	result.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New(`server did not present a certificate`)
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		leaf := cs.PeerCertificates[0]
+		if _, err := leaf.Verify(opts); err != nil {
+			return err
+		}
+		if mode == TLSVerifyFull && leaf.VerifyHostname(cs.ServerName) != nil && leaf.Subject.CommonName != cs.ServerName {
+			return fmt.Errorf(`server certificate is not valid for %s`, cs.ServerName)
+		}
+		return nil
+	}
+	return result, nil
+}
+
+// NewExternalDBPoolTLS - connects using TLS configured by o, the server is verified unless o.Mode is TLSInsecure
+// expects a postgresql connection string the in the form of  "user=xxx password=xxxx host=xx.xx.xx.xx port=xxxx dbname=xxxx"
+func NewExternalDBPoolTLS(cparms string, o TLSOptions, opts ...PoolOption) (*DBPool, error) {
+	tlsc, err := NewTLSConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	return getConnection(cparms, tlsc, opts)
+}
@@ -0,0 +1,93 @@
+package pgdb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCert - creates a certificate for cn, signed by parent (self signed when parent is nil)
+func newTestCert(t *testing.T, cn string, dns []string, parent *tls.Certificate) (tls.Certificate, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dns,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der})
+}
+
+// handshake - returns the client side result of a TLS handshake against a server presenting cert
+func handshake(cfg *tls.Config, serverName string, cert tls.Certificate) error {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		_ = tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+	cfg = cfg.Clone()
+	if len(cfg.ServerName) == 0 {
+		cfg.ServerName = serverName
+	}
+	return tls.Client(c, cfg).Handshake()
+}
+
+func Test_NewTLSConfig(t *testing.T) {
+	ca, caPEM := newTestCert(t, `test ca`, nil, nil)
+	_, otherPEM := newTestCert(t, `other ca`, nil, nil)
+	server, _ := newTestCert(t, `project:instance`, []string{`db.example.com`}, &ca)
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		host    string
+		wantErr bool
+	}{
+		{`full`, TLSOptions{ServerCA: caPEM}, `db.example.com`, false},
+		{`full wrong host`, TLSOptions{ServerCA: caPEM}, `other.example.com`, true},
+		{`full common name`, TLSOptions{ServerCA: caPEM, ServerName: `project:instance`}, `10.0.0.1`, false},
+		{`full wrong ca`, TLSOptions{ServerCA: otherPEM}, `db.example.com`, true},
+		{`ca`, TLSOptions{Mode: TLSVerifyCA, ServerCA: caPEM}, `other.example.com`, false},
+		{`ca wrong ca`, TLSOptions{Mode: TLSVerifyCA, ServerCA: otherPEM}, `db.example.com`, true},
+		{`insecure`, TLSOptions{Mode: TLSInsecure}, `other.example.com`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewTLSConfig(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = handshake(cfg, tt.host, server); (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewTLSConfig(TLSOptions{Mode: TLSVerifyCA}); err == nil {
+		t.Error(`verify-ca without a CA should fail`)
+	}
+}
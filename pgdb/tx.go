@@ -0,0 +1,120 @@
+package pgdb
+
+/*
+	transaction support for DBPool
+	WithTx commits or rolls back automatically and re-runs the work when postgres reports a serialization failure or deadlock
+*/
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// DefaultTxAttempts - number of times WithTx runs the work when TxOptions.MaxAttempts is not set
+const DefaultTxAttempts = 3
+
+// TxOptions - IsoLevel defaults to the server default (normally read committed)
+// Deferrable only has an effect for serializable, read only transactions
+type TxOptions struct {
+	IsoLevel    pgx.TxIsoLevel
+	ReadOnly    bool
+	Deferrable  bool
+	MaxAttempts int
+}
+
+func (o TxOptions) pgxOptions() pgx.TxOptions {
+	result := pgx.TxOptions{IsoLevel: o.IsoLevel}
+	if o.ReadOnly {
+		result.AccessMode = pgx.ReadOnly
+	}
+	if o.Deferrable {
+		result.DeferrableMode = pgx.Deferrable
+	}
+	return result
+}
+
+// Tx - a transaction, or a savepoint within one, passed to the function given to WithTx
+type Tx struct {
+	tx  pgx.Tx
+	obs *observer
+}
+
+// PgxTx - the underlying pgx transaction
+func (t *Tx) PgxTx() pgx.Tx {
+	return t.tx
+}
+
+// QueryContext - execute a sql query within the transaction
+func (t *Tx) QueryContext(ctx context.Context, q string, args ...interface{}) (pgx.Rows, error) {
+	return query(ctx, t.tx, t.obs, func() {}, q, args)
+}
+
+// ExecuteContext - execute a sql command within the transaction (gives count of rows affected)
+func (t *Tx) ExecuteContext(ctx context.Context, q string, args ...interface{}) (int, error) {
+	return execute(ctx, t.tx, t.obs, q, args)
+}
+
+// Savepoint - runs fn within a savepoint, rolled back to if fn returns an error, savepoints may be nested
+// the error returned by fn is passed back, the outer transaction can continue
+func (t *Tx) Savepoint(ctx context.Context, fn func(tx *Tx) error) error {
+	sp, err := t.tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	return finish(ctx, sp, t.obs, fn)
+}
+
+// WithTx - runs fn in a transaction, committed if fn returns nil and rolled back otherwise
+// fn is run again, up to opts.MaxAttempts times, if the transaction fails with a serialization failure or deadlock
+// so it must not have side effects outside of the database
+func (p *DBPool) WithTx(ctx context.Context, opts TxOptions, fn func(tx *Tx) error) error {
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	for i := 1; ; i++ {
+		err := p.runTx(ctx, opts, fn)
+		if err == nil || i >= attempts || !isRetryable(err) {
+			return err
+		}
+		// brief, growing pause to let the conflicting transaction finish
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(i*i) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func (p *DBPool) runTx(ctx context.Context, opts TxOptions, fn func(tx *Tx) error) error {
+	tx, err := p.DBCon.BeginTx(ctx, opts.pgxOptions())
+	if err != nil {
+		return err
+	}
+	return finish(ctx, tx, p.obs, fn)
+}
+
+// finish - commit if fn succeeds, otherwise roll back. Rollback is also performed if fn panics
+func finish(ctx context.Context, tx pgx.Tx, obs *observer, fn func(tx *Tx) error) error {
+	committed := false
+	defer func() {
+		if !committed {
+			// a context that is already done would prevent the rollback being sent
+			_ = tx.Rollback(context.Background())
+		}
+	}()
+	if err := fn(&Tx{tx: tx, obs: obs}); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit(ctx)
+}
+
+// isRetryable - true if the transaction failed only because of contention with another transaction
+func isRetryable(err error) bool {
+	k := KindOf(err)
+	return k == KindSerializationFailure || k == KindDeadlock
+}
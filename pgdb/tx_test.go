@@ -0,0 +1,69 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+	"testing"
+)
+
+func Test_isRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{`serialization`, &pgconn.PgError{Code: pgerrcode.SerializationFailure}, true},
+		{`deadlock`, fmt.Errorf(`wrapped: %w`, &pgconn.PgError{Code: pgerrcode.DeadlockDetected}), true},
+		{`unique`, &pgconn.PgError{Code: pgerrcode.UniqueViolation}, false},
+		{`other`, errors.New(`failed`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_WithTx(t *testing.T) {
+	p := testPool(t)
+	defer p.Close()
+	ctx := context.Background()
+	// pooled connections do not share temporary tables
+	_, _ = p.Execute(`create table if not exists tx_test (id int primary key)`)
+	defer p.Execute(`drop table tx_test`)
+
+	// work is rolled back when fn fails
+	e := p.WithTx(ctx, TxOptions{}, func(tx *Tx) error {
+		if _, err := tx.ExecuteContext(ctx, `insert into tx_test values (1)`); err != nil {
+			return err
+		}
+		return errors.New(`abandon`)
+	})
+	if e == nil || e.Error() != `abandon` {
+		t.Error(`expected error from fn to be returned`, e)
+	}
+
+	// savepoint failure leaves the outer transaction usable
+	attempts := 0
+	e = p.WithTx(ctx, TxOptions{IsoLevel: pgx.Serializable, MaxAttempts: 2}, func(tx *Tx) error {
+		attempts++
+		if _, err := tx.ExecuteContext(ctx, `set local time zone 'UTC'`); err != nil {
+			return err
+		}
+		_ = tx.Savepoint(ctx, func(sp *Tx) error {
+			_, err := sp.ExecuteContext(ctx, `insert into missing_table values (1)`)
+			return err
+		})
+		_, err := tx.ExecuteContext(ctx, `insert into tx_test values (2)`)
+		return err
+	})
+	if e != nil || attempts != 1 {
+		t.Error(`WithTx failed`, e, attempts)
+	}
+}
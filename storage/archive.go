@@ -0,0 +1,388 @@
+package storage
+
+/*
+	bundle the objects under a prefix into a single zip or tar.gz object, and the reverse
+	content is streamed from bucket to bucket, nothing is written to local disk
+*/
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"cloud.google.com/go/storage"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+type ArchiveFormat int
+
+const (
+	ArchiveZip ArchiveFormat = iota
+	ArchiveTarGz
+)
+
+// ArchiveLimits - guards against archive bombs and runaway bundles, zero means no limit
+type ArchiveLimits struct {
+	MaxFiles      int
+	MaxFileBytes  int64
+	MaxTotalBytes int64
+}
+
+// DefaultArchiveLimits - used by a CStore until SetArchiveLimits is called
+var DefaultArchiveLimits = ArchiveLimits{MaxFiles: 10000, MaxFileBytes: 1 << 30, MaxTotalBytes: 4 << 30}
+
+// ErrArchiveLimit - an archive exceeded one of the configured ArchiveLimits
+var ErrArchiveLimit = errors.New(`archive limit exceeded`)
+
+// ErrUnsafeArchivePath - an archive entry name would escape the destination prefix
+var ErrUnsafeArchivePath = errors.New(`unsafe archive entry path`)
+
+// SetArchiveLimits - limits applied by ArchivePrefix and ExtractArchive
+func (cs *CStore) SetArchiveLimits(l ArchiveLimits) {
+	cs.archiveLimits = &l
+}
+
+func (cs *CStore) getArchiveLimits() ArchiveLimits {
+	if cs.archiveLimits == nil {
+		return DefaultArchiveLimits
+	}
+	return *cs.archiveLimits
+}
+
+// ArchivePrefix - writes every object under prefix into destObject, entry names are relative to prefix
+// returns the number of files archived, destObject is excluded if it lives under prefix
+func (cs *CStore) ArchivePrefix(prefix, destObject string, format ArchiveFormat) (int, error) {
+	files, err := cs.GetFileInfo(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var entries []storage.ObjectAttrs
+	for _, oa := range files {
+		if oa.Name != destObject && !strings.HasSuffix(oa.Name, `/`) {
+			entries = append(entries, oa)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ftype := `application/zip`
+	if format == ArchiveTarGz {
+		ftype = `application/gzip`
+	}
+	wc := cs.newWriter(ctx, destObject, ftype)
+	aw, err := newArchiveWriter(wc, format)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := cs.writeArchive(ctx, aw, prefix, entries)
+	if err == nil {
+		err = aw.Close()
+	}
+	if err != nil {
+		cancel() // abandons the upload
+		_ = wc.Close()
+		return 0, err
+	}
+	return cnt, wc.Close()
+}
+
+// writeArchive - each entry is read at the generation that was listed, so its content matches the size in the header
+// an object rewritten since the listing fails with storage.ErrObjectNotExist rather than corrupting the archive
+func (cs *CStore) writeArchive(ctx context.Context, aw archiveWriter, prefix string, entries []storage.ObjectAttrs) (int, error) {
+	limits := cs.getArchiveLimits()
+	var total int64
+	for i, oa := range entries {
+		if limits.MaxFiles > 0 && i >= limits.MaxFiles {
+			return i, ErrArchiveLimit
+		}
+		total += oa.Size
+		if (limits.MaxFileBytes > 0 && oa.Size > limits.MaxFileBytes) || (limits.MaxTotalBytes > 0 && total > limits.MaxTotalBytes) {
+			return i, fmt.Errorf(`%s: %w`, oa.Name, ErrArchiveLimit)
+		}
+		name, err := cleanArchivePath(strings.TrimPrefix(oa.Name, prefix))
+		if err != nil {
+			return i, err
+		}
+		r, err := cs.bucket.Object(oa.Name).Generation(oa.Generation).NewReader(ctx)
+		if err != nil {
+			return i, err
+		}
+		err = aw.Add(name, oa.Size, oa.Updated, r)
+		_ = r.Close()
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// ExtractArchive - unpacks a zip or tar.gz object into objects under destPrefix, the format is detected from the content
+// returns the number of files extracted, stops at the first error leaving any files already written in place
+func (cs *CStore) ExtractArchive(object, destPrefix string) (int, error) {
+	it := cs.bucket.Object(object)
+	ita, err := it.Attrs(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	ex := &extractor{put: cs.writeStream, prefix: destPrefix, limits: cs.getArchiveLimits()}
+	ra := &objectReaderAt{obj: it.Generation(ita.Generation), size: ita.Size}
+	magic := make([]byte, 4)
+	if _, err = ra.ReadAt(magic, 0); err != nil {
+		return 0, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK")):
+		err = ex.extractZip(ra, ita.Size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var r *storage.Reader
+		if r, err = it.Generation(ita.Generation).NewReader(context.Background()); err != nil {
+			return 0, err
+		}
+		err = ex.extractTarGz(r)
+		_ = r.Close()
+	default:
+		err = errors.New(`unrecognised archive format: ` + object)
+	}
+	return ex.count, err
+}
+
+// archiveWriter - common interface over zip and tar.gz output
+type archiveWriter interface {
+	Add(name string, size int64, modified time.Time, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		return &tarArchiveWriter{gw: gw, tw: tar.NewWriter(gw)}, nil
+	}
+	return nil, errors.New(`unsupported archive format`)
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchiveWriter) Add(name string, size int64, modified time.Time, r io.Reader) error {
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarArchiveWriter) Add(name string, size int64, modified time.Time, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Size: size, Mode: 0644, ModTime: modified, Typeflag: tar.TypeReg}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarArchiveWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gw.Close()
+}
+
+// extractor - writes archive entries to the bucket while enforcing limits
+type extractor struct {
+	put    func(fn string, r io.Reader) error
+	prefix string
+	limits ArchiveLimits
+	count  int
+	total  int64
+}
+
+func (ex *extractor) extractZip(ra io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = ex.add(f.Name, r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *extractor) extractTarGz(r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// directories, links and devices have no meaning in a bucket
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = ex.add(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add - validates the entry name and copies at most the permitted number of bytes
+func (ex *extractor) add(name string, r io.Reader) error {
+	if ex.limits.MaxFiles > 0 && ex.count >= ex.limits.MaxFiles {
+		return ErrArchiveLimit
+	}
+	clean, err := cleanArchivePath(name)
+	if err != nil {
+		return err
+	}
+	allowed := int64(-1)
+	if ex.limits.MaxFileBytes > 0 {
+		allowed = ex.limits.MaxFileBytes
+	}
+	if ex.limits.MaxTotalBytes > 0 && (allowed < 0 || ex.limits.MaxTotalBytes-ex.total < allowed) {
+		allowed = ex.limits.MaxTotalBytes - ex.total
+	}
+	lr := &limitedReader{r: r, remaining: allowed}
+	if err = ex.put(ex.prefix+clean, lr); err != nil {
+		return err
+	}
+	ex.count++
+	ex.total += lr.read
+	return nil
+}
+
+// writeStream - copies r into the object, the content type is derived from the file extension
+func (cs *CStore) writeStream(fn string, r io.Reader) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ftype := mime.TypeByExtension(path.Ext(fn))
+	if len(ftype) == 0 {
+		ftype = `application/octet-stream`
+	}
+	wc := cs.newWriter(ctx, fn, ftype)
+	if _, err := io.Copy(wc, r); err != nil {
+		cancel() // abandons the upload
+		_ = wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// cleanArchivePath - rejects entry names that are absolute or that would climb out of the destination (zip-slip)
+func cleanArchivePath(name string) (string, error) {
+	n := strings.ReplaceAll(name, `\`, `/`)
+	if len(n) == 0 || strings.HasPrefix(n, `/`) || (len(n) > 1 && n[1] == ':') {
+		return ``, fmt.Errorf(`%s: %w`, name, ErrUnsafeArchivePath)
+	}
+	for _, part := range strings.Split(n, `/`) {
+		if part == `..` {
+			return ``, fmt.Errorf(`%s: %w`, name, ErrUnsafeArchivePath)
+		}
+	}
+	n = path.Clean(n)
+	if n == `.` {
+		return ``, fmt.Errorf(`%s: %w`, name, ErrUnsafeArchivePath)
+	}
+	return n, nil
+}
+
+// limitedReader - like io.LimitReader but reports ErrArchiveLimit instead of silently truncating
+type limitedReader struct {
+	r         io.Reader
+	remaining int64 // negative means unlimited
+	read      int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining >= 0 && int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.remaining >= 0 {
+		if int64(n) > l.remaining {
+			return 0, ErrArchiveLimit
+		}
+		l.remaining -= int64(n)
+	}
+	return n, err
+}
+
+// objectReaderAt - random access to an object using range reads, with a read-ahead window
+// zip archives keep their directory at the end so they cannot be read as a stream
+type objectReaderAt struct {
+	obj    *storage.ObjectHandle
+	size   int64
+	window []byte
+	offset int64
+}
+
+const readAheadBytes = 1 << 20
+
+func (o *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	if off < o.offset || off+int64(len(p)) > o.offset+int64(len(o.window)) {
+		length := int64(len(p))
+		if length < readAheadBytes {
+			length = readAheadBytes
+		}
+		if off+length > o.size {
+			length = o.size - off
+		}
+		r, err := o.obj.NewRangeReader(context.Background(), off, length)
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, length)
+		_, err = io.ReadFull(r, buf)
+		_ = r.Close()
+		if err != nil {
+			return 0, err
+		}
+		o.window, o.offset = buf, off
+	}
+	n := copy(p, o.window[off-o.offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cleanArchivePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{`a.txt`, `a.txt`, false},
+		{`dir/./b.txt`, `dir/b.txt`, false},
+		{`dir\c.txt`, `dir/c.txt`, false},
+		{`../evil.txt`, ``, true},
+		{`dir/../../evil.txt`, ``, true},
+		{`/etc/passwd`, ``, true},
+		{`C:\evil.txt`, ``, true},
+		{``, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanArchivePath(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("cleanArchivePath() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// buildArchive - creates an in memory archive containing the named files
+func buildArchive(t *testing.T, format ArchiveFormat, files map[string]string) []byte {
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, c := range files {
+		if err = aw.Add(n, int64(len(c)), time.Now(), strings.NewReader(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func memExtractor(limits ArchiveLimits, out map[string]string) *extractor {
+	return &extractor{prefix: `out/`, limits: limits, put: func(fn string, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		if err == nil {
+			out[fn] = string(b)
+		}
+		return err
+	}}
+}
+
+func Test_archiveRoundTrip(t *testing.T) {
+	files := map[string]string{`f1.txt`: fileContents, `sub/f2.json`: `{}`}
+	for _, format := range []ArchiveFormat{ArchiveZip, ArchiveTarGz} {
+		data := buildArchive(t, format, files)
+		out := make(map[string]string)
+		ex := memExtractor(ArchiveLimits{}, out)
+		var err error
+		if format == ArchiveZip {
+			err = ex.extractZip(bytes.NewReader(data), int64(len(data)))
+		} else {
+			err = ex.extractTarGz(bytes.NewReader(data))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ex.count != 2 || out[`out/f1.txt`] != fileContents || out[`out/sub/f2.json`] != `{}` {
+			t.Errorf(`format %d: unexpected extract result %v`, format, out)
+		}
+	}
+}
+
+func Test_archiveLimits(t *testing.T) {
+	data := buildArchive(t, ArchiveZip, map[string]string{`f1.txt`: fileContents})
+	ex := memExtractor(ArchiveLimits{MaxFileBytes: 10}, make(map[string]string))
+	if err := ex.extractZip(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrArchiveLimit) {
+		t.Errorf(`Expected archive limit error, got %v`, err)
+	}
+	data = buildArchive(t, ArchiveTarGz, map[string]string{`a.txt`: `a`, `b.txt`: `b`})
+	ex = memExtractor(ArchiveLimits{MaxFiles: 1}, make(map[string]string))
+	if err := ex.extractTarGz(bytes.NewReader(data)); !errors.Is(err, ErrArchiveLimit) {
+		t.Errorf(`Expected archive limit error, got %v`, err)
+	}
+}
+
+func Test_archiveZipSlip(t *testing.T) {
+	data := buildArchive(t, ArchiveZip, map[string]string{`../../evil.txt`: `x`})
+	out := make(map[string]string)
+	ex := memExtractor(ArchiveLimits{}, out)
+	if err := ex.extractZip(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrUnsafeArchivePath) {
+		t.Errorf(`Expected unsafe path error, got %v`, err)
+	}
+	if len(out) != 0 {
+		t.Error(`nothing should have been extracted`)
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+/*
+	optional read-through cache for CStore objects
+	entries are keyed by object name and generation, held either in memory or in a local directory
+*/
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheOptions - configuration for the read-through cache
+// MaxBytes / MaxEntries of zero means unlimited
+// TTL - entries younger than this are served without checking the bucket, zero means always revalidate
+// Dir - if specified, cached content is written to this local directory rather than held in memory
+type CacheOptions struct {
+	MaxBytes   int64
+	MaxEntries int
+	TTL        time.Duration
+	Dir        string
+}
+
+// CacheStats - counters describing cache effectiveness
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Revalidations int64
+	Evictions     int64
+	Invalidations int64
+	Entries       int
+	Bytes         int64
+}
+
+type cacheEntry struct {
+	name       string
+	generation int64
+	size       int64
+	data       []byte
+	validated  time.Time
+}
+
+type objectCache struct {
+	mu      sync.Mutex
+	opts    CacheOptions
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+func newObjectCache(opts CacheOptions) (*objectCache, error) {
+	if len(opts.Dir) > 0 {
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	this := new(objectCache)
+	this.opts = opts
+	this.lru = list.New()
+	this.entries = make(map[string]*list.Element)
+	return this, nil
+}
+
+// get - returns cached content for the object, fresh is true when the entry is within the TTL
+func (c *objectCache) get(name string) (ce *cacheEntry, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	ce = el.Value.(*cacheEntry)
+	return ce, c.opts.TTL > 0 && time.Since(ce.validated) < c.opts.TTL
+}
+
+// load - returns the content for an entry obtained from get, a copy the caller may modify
+func (c *objectCache) load(ce *cacheEntry) ([]byte, error) {
+	if len(c.opts.Dir) == 0 {
+		return append([]byte(nil), ce.data...), nil
+	}
+	return ioutil.ReadFile(c.filePath(ce.name, ce.generation))
+}
+
+// hit - record a successful read of the entry, revalidated indicates the generation was confirmed with the bucket
+func (c *objectCache) hit(name string, revalidated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Hits++
+	if el, ok := c.entries[name]; ok {
+		c.lru.MoveToFront(el)
+		if revalidated {
+			c.stats.Revalidations++
+			el.Value.(*cacheEntry).validated = time.Now()
+		}
+	}
+}
+
+// miss - record a read that had to be satisfied from the bucket
+func (c *objectCache) miss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Misses++
+}
+
+// put - store content for an object generation, evicting older entries as required
+// the content is copied, so the caller may go on to modify data
+func (c *objectCache) put(name string, generation int64, data []byte) error {
+	size := int64(len(data))
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		return nil // too large to ever fit
+	}
+	ce := &cacheEntry{name: name, generation: generation, size: size, validated: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.remove(el)
+	}
+	if len(c.opts.Dir) > 0 {
+		if err := c.writeFile(c.filePath(name, generation), data); err != nil {
+			return err
+		}
+	} else {
+		ce.data = append([]byte(nil), data...)
+	}
+	c.entries[name] = c.lru.PushFront(ce)
+	c.stats.Entries++
+	c.stats.Bytes += size
+	for c.overLimit() {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return nil
+}
+
+// invalidate - drop any entry held for the object
+func (c *objectCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.remove(el)
+		c.stats.Invalidations++
+	}
+}
+
+// clear - drop all entries
+func (c *objectCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *objectCache) getStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *objectCache) overLimit() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	return (c.opts.MaxEntries > 0 && c.stats.Entries > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.stats.Bytes > c.opts.MaxBytes)
+}
+
+// remove - caller must hold the lock
+func (c *objectCache) remove(el *list.Element) {
+	ce := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, ce.name)
+	c.stats.Entries--
+	c.stats.Bytes -= ce.size
+	if len(c.opts.Dir) > 0 {
+		_ = os.Remove(c.filePath(ce.name, ce.generation))
+	}
+}
+
+// writeFile - writes to a temporary file renamed into place, so that load never sees a partly written file
+func (c *objectCache) writeFile(fn string, data []byte) error {
+	f, err := ioutil.TempFile(c.opts.Dir, `.tmp-*`)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fn)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (c *objectCache) filePath(name string, generation int64) string {
+	h := sha1.Sum([]byte(name))
+	return filepath.Join(c.opts.Dir, hex.EncodeToString(h[:])+`_`+strconv.FormatInt(generation, 10))
+}
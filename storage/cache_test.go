@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cacheEviction(t *testing.T) {
+	c, _ := newObjectCache(CacheOptions{MaxEntries: 2})
+	_ = c.put(`a`, 1, []byte(`aaa`))
+	_ = c.put(`b`, 1, []byte(`bbb`))
+	c.hit(`a`, false)
+	_ = c.put(`c`, 1, []byte(`ccc`))
+	if ce, _ := c.get(`b`); ce != nil {
+		t.Error(`least recently used entry should have been evicted`)
+	}
+	if ce, _ := c.get(`a`); ce == nil {
+		t.Error(`recently used entry should have been retained`)
+	}
+	s := c.getStats()
+	if s.Evictions != 1 || s.Entries != 2 || s.Bytes != 6 {
+		t.Errorf(`unexpected stats %+v`, s)
+	}
+}
+
+func Test_cacheCopies(t *testing.T) {
+	c, _ := newObjectCache(CacheOptions{})
+	data := []byte(`aaa`)
+	_ = c.put(`a`, 1, data)
+	data[0] = 'x'
+	ce, _ := c.get(`a`)
+	got, _ := c.load(ce)
+	got[1] = 'y'
+	if again, _ := c.load(ce); string(again) != `aaa` {
+		t.Errorf(`cached content was modified through a caller's slice, got %s`, again)
+	}
+}
+
+func Test_cacheMaxBytes(t *testing.T) {
+	c, _ := newObjectCache(CacheOptions{MaxBytes: 5})
+	_ = c.put(`a`, 1, []byte(`aaa`))
+	_ = c.put(`b`, 1, []byte(`bbb`))
+	if s := c.getStats(); s.Entries != 1 || s.Bytes != 3 {
+		t.Errorf(`unexpected stats %+v`, s)
+	}
+	_ = c.put(`big`, 1, []byte(`too large`))
+	if ce, _ := c.get(`big`); ce != nil {
+		t.Error(`oversized entry should not be cached`)
+	}
+}
+
+func Test_cacheTTL(t *testing.T) {
+	c, _ := newObjectCache(CacheOptions{TTL: 50 * time.Millisecond})
+	_ = c.put(`a`, 1, []byte(`aaa`))
+	if _, fresh := c.get(`a`); !fresh {
+		t.Error(`entry should be fresh`)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, fresh := c.get(`a`); fresh {
+		t.Error(`entry should have expired`)
+	}
+	c.hit(`a`, true)
+	if _, fresh := c.get(`a`); !fresh {
+		t.Error(`revalidated entry should be fresh`)
+	}
+	c.invalidate(`a`)
+	if ce, _ := c.get(`a`); ce != nil {
+		t.Error(`entry should have been invalidated`)
+	}
+}
+
+func Test_cacheDir(t *testing.T) {
+	dir, _ := os.MkdirTemp(``, `cstore`)
+	defer os.RemoveAll(dir)
+	c, e := newObjectCache(CacheOptions{Dir: dir})
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = c.put(`a/b.txt`, 7, []byte(fileContents))
+	ce, _ := c.get(`a/b.txt`)
+	if ce == nil {
+		t.Fatal(`entry not found`)
+	}
+	data, e2 := c.load(ce)
+	if e2 != nil || string(data) != fileContents {
+		t.Errorf(`Expected "%s" got "%s" %v`, fileContents, data, e2)
+	}
+	c.clear()
+	if fl, _ := os.ReadDir(dir); len(fl) != 0 {
+		t.Error(`cache files should have been removed`)
+	}
+}
+
+func Test_cacheDirConcurrentLoad(t *testing.T) {
+	dir, _ := os.MkdirTemp(``, `cache`)
+	defer os.RemoveAll(dir)
+	c, _ := newObjectCache(CacheOptions{Dir: dir})
+	long := []byte(strings.Repeat(`x`, 1<<16))
+	_ = c.put(`a`, 1, long)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_ = c.put(`a`, 1, long)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if ce, _ := c.get(`a`); ce != nil {
+			if data, e := c.load(ce); e == nil && len(data) != len(long) {
+				t.Fatalf(`loaded a partly written file of %d bytes`, len(data))
+			}
+		}
+	}
+	<-done
+}
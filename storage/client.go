@@ -0,0 +1,192 @@
+package storage
+
+/*
+	ownership of GCP storage clients
+	a ClientFactory shares one client per set of credentials between the CStores it creates, and closes them all on Close
+*/
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"google.golang.org/api/option"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// EmulatorHostEnv - when this environment variable holds a host:port, clients talk to a storage emulator without authentication
+const EmulatorHostEnv = `STORAGE_EMULATOR_HOST`
+
+type clientConfig struct {
+	userProject string
+	endpoint    string
+	httpClient  *http.Client
+	noAuth      bool
+}
+
+// Option - configures the storage client and / or bucket used by a CStore
+type Option func(*clientConfig)
+
+// WithUserProject - project billed for requests made against a requester pays bucket
+func WithUserProject(project string) Option {
+	return func(c *clientConfig) {
+		c.userProject = project
+	}
+}
+
+// WithEndpoint - overrides the default storage API endpoint, including the one taken from EmulatorHostEnv
+func WithEndpoint(url string) Option {
+	return func(c *clientConfig) {
+		c.endpoint = url
+	}
+}
+
+// WithHTTPClient - http client used for all requests, it must supply its own authentication
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *clientConfig) {
+		c.httpClient = hc
+	}
+}
+
+// WithoutAuthentication - no credentials are sent, for public buckets and local emulators
+// this is always the case when EmulatorHostEnv is set, see UsingEmulator
+func WithoutAuthentication() Option {
+	return func(c *clientConfig) {
+		c.noAuth = true
+	}
+}
+
+// WithEmulator - points the client at a storage emulator such as fake-gcs-server, without authentication
+// host may be given as host:port (http is assumed) or as a url
+func WithEmulator(host string) Option {
+	return func(c *clientConfig) {
+		if !strings.Contains(host, `://`) {
+			host = `http://` + host
+		}
+		c.endpoint = strings.TrimSuffix(host, `/`) + `/storage/v1/`
+		c.noAuth = true
+	}
+}
+
+// UsingEmulator - true if EmulatorHostEnv is set. The storage library then disables authentication for every
+// client it creates, so credentials passed to NewCStore or a ClientFactory are ignored rather than rejected.
+// WithEndpoint, WithEmulator and WithHTTPClient still apply
+func UsingEmulator() bool {
+	return len(os.Getenv(EmulatorHostEnv)) > 0
+}
+
+func newClientConfig(opts []Option) *clientConfig {
+	this := new(clientConfig)
+	for _, o := range opts {
+		o(this)
+	}
+	return this
+}
+
+// clientOptions - translate configuration into options for storage.NewClient
+// when cred is empty the client relies on ambient permissions
+func (c *clientConfig) clientOptions(cred []byte) []option.ClientOption {
+	var result []option.ClientOption
+	switch {
+	case UsingEmulator():
+		// the storage library disables authentication itself, and rejects credentials given alongside
+	case c.noAuth:
+		result = append(result, option.WithoutAuthentication())
+	case len(cred) > 0:
+		result = append(result, option.WithCredentialsJSON(cred))
+	}
+	if len(c.endpoint) > 0 {
+		result = append(result, option.WithEndpoint(c.endpoint))
+	}
+	if c.httpClient != nil {
+		result = append(result, option.WithHTTPClient(c.httpClient))
+	}
+	return result
+}
+
+func (c *clientConfig) newCStore(client *storage.Client, cred []byte, bucketName string) *CStore {
+	this := new(CStore)
+	this.client = client
+	this.bucket = client.Bucket(bucketName)
+	if len(c.userProject) > 0 {
+		this.bucket = this.bucket.UserProject(c.userProject)
+	}
+	this.credentials = cred
+	return this
+}
+
+// ClientFactory - creates CStores that share storage clients, one client per set of credentials
+// safe for concurrent use, call Close when finished to release the clients
+type ClientFactory struct {
+	mu      sync.Mutex
+	cfg     *clientConfig
+	clients map[[sha256.Size]byte]*storage.Client
+	closed  bool
+}
+
+// NewClientFactory - options apply to every client and CStore created by the factory
+func NewClientFactory(opts ...Option) *ClientFactory {
+	this := new(ClientFactory)
+	this.cfg = newClientConfig(opts)
+	this.clients = make(map[[sha256.Size]byte]*storage.Client)
+	return this
+}
+
+// NewCStore - creates a CStore for the bucket using the given credentials, reusing any client already created for them
+func (f *ClientFactory) NewCStore(cred []byte, bucketName string) (*CStore, error) {
+	if len(cred) == 0 || len(bucketName) == 0 {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	client, err := f.getClient(cred)
+	if err != nil {
+		return nil, err
+	}
+	return f.cfg.newCStore(client, cred, bucketName), nil
+}
+
+// NewCStoreP - creates a CStore for the bucket that relies on ambient permissions, no credentials required
+func (f *ClientFactory) NewCStoreP(bucketName string) (*CStore, error) {
+	if len(bucketName) == 0 {
+		return nil, errors.New(`invalid parameter(s)`)
+	}
+	client, err := f.getClient(nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.cfg.newCStore(client, nil, bucketName), nil
+}
+
+// Close - closes every client created by the factory, CStores obtained from it must no longer be used
+func (f *ClientFactory) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result error
+	for k, c := range f.clients {
+		if err := c.Close(); err != nil && result == nil {
+			result = err
+		}
+		delete(f.clients, k)
+	}
+	f.closed = true
+	return result
+}
+
+func (f *ClientFactory) getClient(cred []byte) (*storage.Client, error) {
+	key := sha256.Sum256(cred)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, errors.New(`client factory is closed`)
+	}
+	if c, ok := f.clients[key]; ok {
+		return c, nil
+	}
+	c, err := storage.NewClient(context.Background(), f.cfg.clientOptions(cred)...)
+	if err != nil {
+		return nil, err
+	}
+	f.clients[key] = c
+	return c, nil
+}
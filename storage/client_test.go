@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_ClientFactory(t *testing.T) {
+	f := NewClientFactory(WithHTTPClient(http.DefaultClient), WithUserProject(`billing`))
+	cs1, e := f.NewCStoreP(`Sample`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cs2, _ := f.NewCStoreP(`Sample2`)
+	if cs1.client != cs2.client {
+		t.Error(`Storage client should have been reused`)
+	}
+	cs3, _ := f.NewCStore([]byte(`other credentials`), `Sample`)
+	if cs3.client == cs1.client {
+		t.Error(`Different credentials should not share a client`)
+	}
+	if e = cs1.Close(); e != nil || cs1.client == nil {
+		t.Error(`Closing a factory CStore should not close the shared client`)
+	}
+	if e = f.Close(); e != nil {
+		t.Error(e)
+	}
+	if _, e = f.NewCStoreP(`Sample`); e == nil {
+		t.Error(`should not create stores from a closed factory`)
+	}
+}
+
+func Test_CStoreClose(t *testing.T) {
+	c, e := NewCStoreP(`Sample`, WithHTTPClient(http.DefaultClient))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = c.Close(); e != nil || c.client != nil {
+		t.Error(`CStore should have closed its own client`)
+	}
+	if _, e = NewCStoreP(``); e == nil {
+		t.Error(`expected error for blank bucket name`)
+	}
+}
+
+func Test_WithEmulator(t *testing.T) {
+	cfg := newClientConfig([]Option{WithEmulator(`localhost:4443`)})
+	if cfg.endpoint != `http://localhost:4443/storage/v1/` || !cfg.noAuth {
+		t.Errorf(`unexpected emulator config %+v`, cfg)
+	}
+	cfg = newClientConfig([]Option{WithEmulator(`https://localhost:4443/`)})
+	if cfg.endpoint != `https://localhost:4443/storage/v1/` {
+		t.Errorf(`unexpected emulator endpoint %s`, cfg.endpoint)
+	}
+	c, e := NewCStore([]byte(`not json`), `Sample`, WithEmulator(`localhost:4443`))
+	if e != nil {
+		t.Fatal(`credentials should be ignored without authentication: `, e)
+	}
+	_ = c.Close()
+}
+
+func Test_UsingEmulatorOptions(t *testing.T) {
+	t.Setenv(EmulatorHostEnv, `localhost:4443`)
+	cfg := newClientConfig([]Option{WithEndpoint(`http://localhost:9000/storage/v1/`)})
+	if opts := cfg.clientOptions([]byte(`credentials`)); len(opts) != 1 {
+		t.Errorf(`expected only the endpoint option with an emulator, got %d options`, len(opts))
+	}
+	if !UsingEmulator() {
+		t.Error(`expected the emulator to be in use`)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"errors"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
@@ -18,9 +17,12 @@ import (
 )
 
 type CStore struct {
-	client      *storage.Client
-	bucket      *storage.BucketHandle
-	credentials []byte
+	client        *storage.Client
+	bucket        *storage.BucketHandle
+	credentials   []byte
+	cache         *objectCache
+	ownsClient    bool
+	archiveLimits *ArchiveLimits
 }
 
 func (cs *CStore) Client() *storage.Client {
@@ -31,45 +33,46 @@ func (cs *CStore) Bucket() *storage.BucketHandle {
 	return cs.bucket
 }
 
-var defaultClient *storage.Client
-
 // NewCStore - creates an object suitable for accessing a predefined Bucket in GCP Cloud Storage
-func NewCStore(cred []byte, bucketName string) (*CStore, error) {
-	this := new(CStore)
+// the CStore owns its client, call Close when finished with it. cred is ignored when UsingEmulator is true
+func NewCStore(cred []byte, bucketName string, opts ...Option) (*CStore, error) {
 	if len(cred) == 0 || len(bucketName) == 0 {
 		return nil, errors.New(`invalid parameter(s)`)
 	}
-	var err error
-	this.client, err = storage.NewClient(context.Background(), option.WithCredentialsJSON(cred))
-	if err == nil && this.client != nil {
-		if defaultClient == nil {
-			defaultClient = this.client
-		}
-		this.bucket = this.client.Bucket(bucketName)
-	}
-	this.credentials = cred
-	return this, err
+	return newOwnedCStore(cred, bucketName, opts)
 }
 
 // NewCStoreP - creates an object suitable for accessing a predefined Bucket in GCP Cloud Storage, assumes permissions exist, no credentials required
-// note - it will reuse the default client for any subsequent calls.
-func NewCStoreP(bucketName string) (*CStore, error) {
-	this := new(CStore)
+// the CStore owns its client, call Close when finished with it. Use a ClientFactory to share a client between buckets.
+func NewCStoreP(bucketName string, opts ...Option) (*CStore, error) {
 	if len(bucketName) == 0 {
 		return nil, errors.New(`invalid parameter(s)`)
 	}
-	if defaultClient == nil {
-		var err error
-		defaultClient, err = storage.NewClient(context.Background())
-		if err != nil {
-			return nil, err
-		}
+	return newOwnedCStore(nil, bucketName, opts)
+}
+
+func newOwnedCStore(cred []byte, bucketName string, opts []Option) (*CStore, error) {
+	cfg := newClientConfig(opts)
+	client, err := storage.NewClient(context.Background(), cfg.clientOptions(cred)...)
+	if err != nil {
+		return nil, err
 	}
-	this.client = defaultClient
-	this.bucket = this.client.Bucket(bucketName)
+	this := cfg.newCStore(client, cred, bucketName)
+	this.ownsClient = true
 	return this, nil
 }
 
+// Close - releases the client if this CStore created it, clients shared through a ClientFactory are closed by the factory
+func (cs *CStore) Close() error {
+	cs.ClearCache()
+	if cs.ownsClient && cs.client != nil {
+		err := cs.client.Close()
+		cs.client = nil
+		return err
+	}
+	return nil
+}
+
 // GetFiles - return list of files within specified bucket / path
 func (cs *CStore) GetFiles(path string) ([]string, error) {
 	var result []string
@@ -186,10 +189,54 @@ func (cs *CStore) GetFileReader(fn string) (*storage.Reader, int64, error) {
 	return r, fsize, nil
 }
 
+// ReadFile - returns the entire contents of the file, served from the cache when enabled
+// content read from the bucket is CRC32C checked by the client library, see ReadVerifiedFile for an MD5 check
+func (cs *CStore) ReadFile(fn string) ([]byte, error) {
+	it := cs.bucket.Object(fn)
+	if cs.cache != nil {
+		ce, fresh := cs.cache.get(fn)
+		if ce != nil {
+			revalidated := false
+			if !fresh {
+				ita, err := it.Attrs(context.Background())
+				if err != nil {
+					if err == storage.ErrObjectNotExist {
+						cs.cache.invalidate(fn)
+					}
+					return nil, err
+				}
+				revalidated = ita.Generation == ce.generation
+			}
+			if fresh || revalidated {
+				if data, err := cs.cache.load(ce); err == nil {
+					cs.cache.hit(fn, revalidated)
+					return data, nil
+				}
+			}
+		}
+		cs.cache.miss()
+	}
+	r, err := it.NewReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if cs.cache != nil {
+		// the cache is best effort, failing to store the content (e.g. a full disk) should not fail the read
+		_ = cs.cache.put(fn, r.Attrs.Generation, data)
+	}
+	return data, nil
+}
+
 // DeleteCloudFile -
 func (cs *CStore) DeleteCloudFile(fn string) error {
 	it := cs.bucket.Object(fn)
 	err := it.Delete(context.Background())
+	cs.invalidate(fn)
 	if err != nil {
 		return err
 	}
@@ -212,9 +259,13 @@ func (cs *CStore) WriteFile(fn string, content string) error {
 // fn is the dest filename/path
 // content - what to write
 // ftype is the Mime contentType
+// the MD5 and CRC32C of content are sent with the upload, the server rejects the write if they do not match
 func (cs *CStore) WriteCloudFile(fn string, content []byte, ftype string) error {
-	wc := cs.bucket.Object(fn).NewWriter(context.Background())
-	wc.ContentType = ftype
+	wc := cs.newWriter(context.Background(), fn, ftype)
+	sum := checksumOf(content)
+	wc.MD5 = sum.MD5
+	wc.CRC32C = sum.CRC32C
+	wc.SendCRC32C = true
 	if _, err := wc.Write(content); err != nil {
 		return err
 	}
@@ -224,12 +275,35 @@ func (cs *CStore) WriteCloudFile(fn string, content []byte, ftype string) error
 	return nil
 }
 
+// cacheWriter - invalidates any cached copy of the object once the upload has finished, a read made while it
+// was in progress may have cached the previous generation
+type cacheWriter struct {
+	*storage.Writer
+	cs *CStore
+	fn string
+}
+
+func (w *cacheWriter) Close() error {
+	err := w.Writer.Close()
+	w.cs.invalidate(w.fn)
+	return err
+}
+
+// newWriter - all writes pass through here so that any cached copy of the object is invalidated
+func (cs *CStore) newWriter(ctx context.Context, fn string, ftype string) *cacheWriter {
+	cs.invalidate(fn)
+	wc := cs.bucket.Object(fn).NewWriter(ctx)
+	wc.ContentType = ftype
+	return &cacheWriter{Writer: wc, cs: cs, fn: fn}
+}
+
 // CopyFile - from/to locations within the cloud
 func (cs *CStore) CopyFile(srcName string, destcs *CStore, dest string) error {
 	s := cs.bucket.Object(srcName)
 	d := destcs.bucket.Object(dest)
 
 	_, err := d.CopierFrom(s).Run(context.Background())
+	destcs.invalidate(dest)
 	if err != nil {
 		return err
 	}
@@ -262,21 +336,47 @@ func (cs *CStore) CreateDownloadURL(minutes int, path string) (string, error) {
 // dest is local file path
 func (cs *CStore) DownloadFiles(files []string, dest string) error {
 	for _, fn := range files {
-		cr, _, err := cs.GetFileReader(fn)
-		if err != nil {
-			return err
+		c2, e1 := cs.ReadFile(fn)
+		if e1 != nil {
+			return e1
 		}
-		if cr != nil {
-			c2, e1 := ioutil.ReadAll(cr)
-			if e1 != nil {
-				return e1
-			}
-			fb := filepath.Base(fn)
-			e2 := ioutil.WriteFile(dest+fb, c2, 0644)
-			if e2 != nil {
-				return e2
-			}
+		fb := filepath.Base(fn)
+		e2 := ioutil.WriteFile(dest+fb, c2, 0644)
+		if e2 != nil {
+			return e2
 		}
 	}
 	return nil
 }
+
+// EnableCache - turns on the read-through cache used by ReadFile and DownloadFiles
+// writes, copies and deletes made through this CStore invalidate the affected entries once they complete
+func (cs *CStore) EnableCache(opts CacheOptions) error {
+	c, err := newObjectCache(opts)
+	if err != nil {
+		return err
+	}
+	cs.cache = c
+	return nil
+}
+
+// CacheStats - returns the current cache counters, zero values if the cache is not enabled
+func (cs *CStore) CacheStats() CacheStats {
+	if cs.cache == nil {
+		return CacheStats{}
+	}
+	return cs.cache.getStats()
+}
+
+// ClearCache - discards all cached entries
+func (cs *CStore) ClearCache() {
+	if cs.cache != nil {
+		cs.cache.clear()
+	}
+}
+
+func (cs *CStore) invalidate(fn string) {
+	if cs.cache != nil {
+		cs.cache.invalidate(fn)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"cloud.google.com/go/storage"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/cambefus/gcp_go_utils/secrets"
@@ -21,7 +22,19 @@ var tfc = 0
 const fileContents = "this is sample content \n for the file"
 const testPath = `testing/`
 
+// bucket created on the emulator when STORAGE_EMULATOR_HOST is set
+const emulatorBucket = `utilities-test`
+
 func setup(t *testing.T) {
+	if cs == nil && UsingEmulator() {
+		tmp, e := NewCStoreP(emulatorBucket)
+		if e != nil {
+			t.Fatal(e)
+		}
+		// the bucket may already exist from a previous run
+		_ = tmp.Bucket().Create(context.Background(), `test-project`, nil)
+		cs = tmp
+	}
 	if cs == nil {
 		s, e := secrets.InitializeFromEnvironment(`utilities_config`)
 		if e != nil {
@@ -80,9 +93,8 @@ func Test_NewCStoreP(t *testing.T) {
 		t.Error("GCP Storage: should not have found files")
 	}
 
-	csp2, _ := NewCStoreP("Sample2")
-	if !(csp2.client == csp.client) {
-		t.Error("Storage client should have been reused")
+	if e := csp.Close(); e != nil {
+		t.Error(e)
 	}
 }
 
@@ -238,6 +250,9 @@ func Test_getFilesWithSuffix(t *testing.T) {
 }
 
 func Test_CreateDownloadURL(t *testing.T) {
+	if UsingEmulator() {
+		t.Skip(`signed urls require service account credentials`)
+	}
 	setup(t)
 	f := writeTestFiles(t)
 	r, e := cs.GetFiles(testPath)
@@ -276,3 +291,83 @@ func Test_GetFileInfo(t *testing.T) {
 	}
 
 }
+
+func Test_ReadFileCache(t *testing.T) {
+	setup(t)
+	f := writeTestFiles(t)
+	defer deleteTestFiles(t, f)
+	if e := cs.EnableCache(CacheOptions{MaxEntries: 10}); e != nil {
+		t.Fatal(e)
+	}
+	defer func() { cs.cache = nil }()
+	for i := 0; i < 2; i++ {
+		c, e := cs.ReadFile(f[0])
+		if e != nil || string(c) != fileContents {
+			t.Errorf(`Expected "%s" got "%s" %v`, fileContents, c, e)
+		}
+	}
+	if s := cs.CacheStats(); s.Hits != 1 || s.Misses != 1 || s.Revalidations != 1 {
+		t.Errorf(`unexpected cache stats %+v`, s)
+	}
+	_ = cs.WriteFile(f[0], `changed`)
+	c, _ := cs.ReadFile(f[0])
+	if string(c) != `changed` {
+		t.Error(`cache entry should have been invalidated by write`)
+	}
+}
+
+func Test_Integrity(t *testing.T) {
+	setup(t)
+	f := writeTestFiles(t)
+	defer deleteTestFiles(t, f)
+	const local = `./integrity.txt`
+	_ = ioutil.WriteFile(local, []byte(fileContents), 0644)
+	defer os.Remove(local)
+	if e := cs.VerifyLocalFile(local, f[0]); e != nil {
+		t.Error(e)
+	}
+	_ = ioutil.WriteFile(local, []byte(`changed`), 0644)
+	if e := cs.VerifyLocalFile(local, f[0]); !errors.Is(e, ErrChecksumMismatch) {
+		t.Errorf(`Expected checksum mismatch, got %v`, e)
+	}
+	if c, e := cs.ReadVerifiedFile(f[1]); e != nil || string(c) != fileContents {
+		t.Errorf(`Expected "%s" got "%s" %v`, fileContents, c, e)
+	}
+
+	prefix := filepath.Dir(f[0]) + `/`
+	r, e := cs.AuditPrefix(prefix, Manifest{
+		`f1.txt`:      checksumOf([]byte(fileContents)),
+		`f2.json`:     checksumOf([]byte(`changed`)),
+		`missing.txt`: checksumOf([]byte(fileContents)),
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(r.Missing) != 1 || len(r.Extra) != 0 || len(r.Corrupted) != 1 || r.OK() {
+		t.Errorf(`unexpected audit report %+v`, r)
+	}
+}
+
+func Test_Archive(t *testing.T) {
+	setup(t)
+	f := writeTestFiles(t)
+	prefix := filepath.Dir(f[0]) + `/`
+	for _, format := range []ArchiveFormat{ArchiveZip, ArchiveTarGz} {
+		archive := testPath + `bundle` + strconv.Itoa(int(format))
+		n, e := cs.ArchivePrefix(prefix, archive, format)
+		if e != nil || n != 2 {
+			t.Fatalf(`ArchivePrefix: expected 2 files, got %d %v`, n, e)
+		}
+		dest := testPath + `extract` + strconv.Itoa(int(format)) + `/`
+		n, e = cs.ExtractArchive(archive, dest)
+		if e != nil || n != 2 {
+			t.Errorf(`ExtractArchive: expected 2 files, got %d %v`, n, e)
+		}
+		c, e := cs.ReadFile(dest + `f1.txt`)
+		if e != nil || string(c) != fileContents {
+			t.Errorf(`Expected "%s" got "%s" %v`, fileContents, c, e)
+		}
+		f = append(f, archive, dest+`f1.txt`, dest+`f2.json`)
+	}
+	deleteTestFiles(t, f)
+}
@@ -0,0 +1,181 @@
+package storage
+
+/*
+	checksum helpers used to verify that uploads, downloads and local copies match what is stored in the bucket
+*/
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrChecksumMismatch - returned when content does not match the checksum recorded for the object
+var ErrChecksumMismatch = errors.New(`checksum mismatch`)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum - MD5 and CRC32C of a piece of content, MD5 is nil for composite objects
+type Checksum struct {
+	MD5    []byte
+	CRC32C uint32
+}
+
+// Matches - compares using MD5 when both sides have it, otherwise CRC32C
+func (c Checksum) Matches(o Checksum) bool {
+	if len(c.MD5) > 0 && len(o.MD5) > 0 {
+		return bytes.Equal(c.MD5, o.MD5)
+	}
+	return c.CRC32C == o.CRC32C
+}
+
+// ComputeChecksum - calculates MD5 and CRC32C of everything read from r
+func ComputeChecksum(r io.Reader) (Checksum, error) {
+	m := md5.New()
+	c := crc32.New(crc32cTable)
+	if _, err := io.Copy(io.MultiWriter(m, c), r); err != nil {
+		return Checksum{}, err
+	}
+	return Checksum{MD5: m.Sum(nil), CRC32C: c.Sum32()}, nil
+}
+
+func checksumOf(content []byte) Checksum {
+	s := md5.Sum(content)
+	return Checksum{MD5: s[:], CRC32C: crc32.Checksum(content, crc32cTable)}
+}
+
+func attrsChecksum(oa *storage.ObjectAttrs) Checksum {
+	return Checksum{MD5: oa.MD5, CRC32C: oa.CRC32C}
+}
+
+// ReadVerifiedFile - returns the entire contents of the file after confirming they match the stored checksum
+// bypasses the cache, the generation read is pinned to the one whose checksum was retrieved
+func (cs *CStore) ReadVerifiedFile(fn string) ([]byte, error) {
+	it := cs.bucket.Object(fn)
+	ita, err := it.Attrs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r, err := it.Generation(ita.Generation).NewReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !checksumOf(data).Matches(attrsChecksum(ita)) {
+		return nil, fmt.Errorf(`%s: %w`, fn, ErrChecksumMismatch)
+	}
+	return data, nil
+}
+
+// VerifyLocalFile - returns nil if the local file matches the stored object, ErrChecksumMismatch if it differs
+func (cs *CStore) VerifyLocalFile(localPath, objectName string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	local, err := ComputeChecksum(f)
+	if err != nil {
+		return err
+	}
+	ita, err := cs.bucket.Object(objectName).Attrs(context.Background())
+	if err != nil {
+		return err
+	}
+	if !local.Matches(attrsChecksum(ita)) {
+		return fmt.Errorf(`%s: %w`, objectName, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// Manifest - expected checksums keyed by object name relative to a prefix, using '/' separators
+type Manifest map[string]Checksum
+
+// BuildManifest - walks a local directory and computes the checksum of every file within it
+func BuildManifest(localDir string) (Manifest, error) {
+	result := make(Manifest)
+	err := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		c, err := ComputeChecksum(f)
+		if err != nil {
+			return err
+		}
+		result[filepath.ToSlash(rel)] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AuditReport - result of comparing a manifest against the contents of a bucket prefix
+// Missing - in the manifest but not in the bucket
+// Extra - in the bucket but not in the manifest
+// Corrupted - present in both but with differing checksums
+// each list is sorted by name
+type AuditReport struct {
+	Missing   []string
+	Extra     []string
+	Corrupted []string
+}
+
+// OK - true if the bucket contents exactly match the manifest
+func (ar *AuditReport) OK() bool {
+	return len(ar.Missing) == 0 && len(ar.Extra) == 0 && len(ar.Corrupted) == 0
+}
+
+// AuditPrefix - compares the manifest to the objects stored under prefix
+// manifest names are relative to prefix, report entries are full object names
+func (cs *CStore) AuditPrefix(prefix string, manifest Manifest) (*AuditReport, error) {
+	result := new(AuditReport)
+	seen := make(map[string]bool, len(manifest))
+	err := cs.GetFilteredFiles(prefix, func(oa *storage.ObjectAttrs) bool {
+		rel := strings.TrimPrefix(oa.Name, prefix)
+		if expected, ok := manifest[rel]; ok {
+			seen[rel] = true
+			if !expected.Matches(attrsChecksum(oa)) {
+				result.Corrupted = append(result.Corrupted, oa.Name)
+			}
+		} else {
+			result.Extra = append(result.Extra, oa.Name)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for rel := range manifest {
+		if !seen[rel] {
+			result.Missing = append(result.Missing, prefix+rel)
+		}
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+	sort.Strings(result.Corrupted)
+	return result, nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_checksumMatches(t *testing.T) {
+	a := checksumOf([]byte(fileContents))
+	b, e := ComputeChecksum(strings.NewReader(fileContents))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !a.Matches(b) {
+		t.Error(`identical content should match`)
+	}
+	c := checksumOf([]byte(`other`))
+	if a.Matches(c) {
+		t.Error(`different content should not match`)
+	}
+	// composite objects have no MD5, fall back to CRC32C
+	if !a.Matches(Checksum{CRC32C: b.CRC32C}) || a.Matches(Checksum{CRC32C: c.CRC32C}) {
+		t.Error(`CRC32C comparison failed`)
+	}
+}
+
+func Test_BuildManifest(t *testing.T) {
+	dir, _ := os.MkdirTemp(``, `manifest`)
+	defer os.RemoveAll(dir)
+	_ = os.Mkdir(filepath.Join(dir, `sub`), 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, `f1.txt`), []byte(fileContents), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, `sub`, `f2.txt`), []byte(`other`), 0644)
+	m, e := BuildManifest(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(m) != 2 {
+		t.Fatalf(`Expected 2 manifest entries, got %d`, len(m))
+	}
+	if !m[`sub/f2.txt`].Matches(checksumOf([]byte(`other`))) {
+		t.Error(`unexpected checksum for nested file`)
+	}
+}
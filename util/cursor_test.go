@@ -0,0 +1,55 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorSigner(t *testing.T) {
+	if _, e := NewCursorSigner([]byte(`0123456789abcdef`), 0); e == nil {
+		t.Error(`Expected error for a short key`)
+	}
+	s, e := NewCursorSigner([]byte(`0123456789abcdef0123456789abcdef`), time.Hour)
+	if e != nil {
+		t.Fatal(e)
+	}
+	c, e := s.Encode(`bob`, 42)
+	if e != nil {
+		t.Fatal(e)
+	}
+	got, e := s.Decode(c)
+	if e != nil || !reflect.DeepEqual(got, []interface{}{`bob`, json.Number(`42`)}) {
+		t.Errorf(`Decode() = %v, %v`, got, e)
+	}
+
+	other, _ := NewCursorSigner([]byte(`fedcba9876543210fedcba9876543210`), time.Hour)
+	body, sig, _ := strings.Cut(c, `.`)
+	tests := []struct {
+		name   string
+		signer *CursorSigner
+		cursor string
+	}{
+		{`wrong key`, other, c},
+		{`no signature`, s, body},
+		{`altered body`, s, `x` + body[1:] + `.` + sig},
+		{`garbage`, s, `%%%.%%%`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ce *CursorError
+			if _, e := tt.signer.Decode(tt.cursor); !errors.Is(e, ErrCursorInvalid) || !errors.As(e, &ce) {
+				t.Errorf(`Expected invalid cursor error, got %v`, e)
+			}
+		})
+	}
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	var ce *CursorError
+	if _, e = s.Decode(c); !errors.Is(e, ErrCursorExpired) || !errors.As(e, &ce) || ce.IssuedAt.IsZero() {
+		t.Errorf(`Expected expired cursor error, got %v`, e)
+	}
+}